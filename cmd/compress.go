@@ -1,8 +1,14 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"immich-compress/compress"
 
@@ -19,16 +25,136 @@ func formatSlice[T ~string](formats []T) []string {
 }
 
 var flagsCompress struct {
-	flagDiff           int
-	flagServer         string
-	flagAPIKey         string
-	flagAssetType      string
-	flagAssetUUIDs     []string
-	flagImageQuality   int
-	flagImageFormat    string
-	flagVideoQuality   int
-	flagVideoFormat    string
-	flagVideoContainer string
+	flagDiff             int
+	flagServer           string
+	flagAPIKey           string
+	flagAssetType        string
+	flagAssetUUIDs       []string
+	flagImageQuality     int
+	flagImageFormat      string
+	flagImageMinSSIM     float64
+	flagImageTargetSSIM  float64
+	flagAVIFQuality      int
+	flagAVIFEffort       int
+	flagAVIFSubsample    string
+	flagAVIFBitDepth     int
+	flagMaxImageDim      int
+	flagVideoQuality     int
+	flagVideoTargetVMAF  float64
+	flagVideoMinCRF      int
+	flagVideoMaxCRF      int
+	flagMaxVideoHeight   int
+	flagVideoMaxFPS      float64
+	flagAudioTracks      string
+	flagAudioChannels    int
+	flagAudioCopy        bool
+	flagDataStreams      string
+	flagVideoMinBPP      float64
+	flagDownloadWorkers  int
+	flagImageWorkers     int
+	flagVideoWorkers     int
+	flagUploadWorkers    int
+	flagVideoThreads     int
+	flagKeepGoing        bool
+	flagMaxFailures      int
+	flagVideoHDR         string
+	flagVideoFormat      string
+	flagVideoContainer   string
+	flagDryRun           bool
+	flagStateFile        string
+	flagResume           bool
+	flagDiscardState     bool
+	flagStackPolicy      string
+	flagAlbums           []string
+	flagPeople           []string
+	flagTags             []string
+	flagTakenAfter       time.Time
+	flagTakenBefore      time.Time
+	flagCameraMake       string
+	flagCameraModel      string
+	flagLensModel        string
+	flagCity             string
+	flagState            string
+	flagCountry          string
+	flagFileName         string
+	flagOnlyFavorites    bool
+	flagExcludeFavorites bool
+	flagNotInAlbum       bool
+	flagVisibility       string
+	flagMinSize          string
+	flagLargest          int
+	flagUUIDFile         string
+	flagPolicy           string
+}
+
+// readUUIDFile reads asset UUIDs, one per line, from a file or from stdin
+// when path is "-". Empty lines and lines starting with # are ignored.
+func readUUIDFile(path string, stdin io.Reader) ([]string, error) {
+	reader := stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open uuid file: %w", err)
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var uuids []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		uuids = append(uuids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read uuid file: %w", err)
+	}
+	return uuids, nil
+}
+
+// validateAVIF checks the AVIF flags up front, libvips would only reject
+// them when the first image is encoded
+func validateAVIF(options compress.AVIFOptions) error {
+	if options.Quality < 0 || options.Quality > 100 {
+		return fmt.Errorf("--avif-quality %d is out of range (0-100)", options.Quality)
+	}
+	if options.Effort < 0 || options.Effort > compress.AVIFEffortMax {
+		return fmt.Errorf("--avif-effort %d is out of range (0-%d)", options.Effort, compress.AVIFEffortMax)
+	}
+	if !slices.Contains(compress.AVIFSubsamplesAvailable, options.Subsample) {
+		return fmt.Errorf("unknown --avif-subsample: %s", options.Subsample)
+	}
+	if !slices.Contains(compress.AVIFBitDepthsAvailable, options.BitDepth) {
+		return fmt.Errorf("unsupported --avif-bit-depth: %d", options.BitDepth)
+	}
+	return nil
+}
+
+// validateSSIM checks the SSIM flags, 0 disables them. A target below the
+// minimum could never be accepted.
+func validateSSIM(min, target float64) error {
+	if min < 0 || min > compress.SimilarityMax {
+		return fmt.Errorf("--image-min-ssim %g is out of range (0-%g)", min, compress.SimilarityMax)
+	}
+	if target < 0 || target > compress.SimilarityMax {
+		return fmt.Errorf("--image-target-ssim %g is out of range (0-%g)", target, compress.SimilarityMax)
+	}
+	if min > 0 && target > 0 && target < min {
+		return fmt.Errorf("--image-target-ssim %g is lower than --image-min-ssim %g", target, min)
+	}
+	return nil
+}
+
+// defaultStateFile returns the state file location inside the user cache directory
+func defaultStateFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "immich-compress", "state.jsonl")
 }
 
 // Config holds configuration for compression command
@@ -49,20 +175,106 @@ var compressCmd = &cobra.Command{
 	Short: "Compress existing fotos/videos",
 	Long:  `A longer description TODO`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if flagsCompress.flagVideoMinCRF > flagsCompress.flagVideoMaxCRF {
+			return fmt.Errorf("--video-min-crf %d is higher than --video-max-crf %d", flagsCompress.flagVideoMinCRF, flagsCompress.flagVideoMaxCRF)
+		}
+		if flagsCompress.flagVideoTargetVMAF < 0 || flagsCompress.flagVideoTargetVMAF > compress.VMAFMax {
+			return fmt.Errorf("--video-target-vmaf %g is out of range (0-%g)", flagsCompress.flagVideoTargetVMAF, compress.VMAFMax)
+		}
+		if flagsCompress.flagMaxFailures != 0 && !flagsCompress.flagKeepGoing {
+			return fmt.Errorf("--max-failures needs --keep-going")
+		}
+		if flagsCompress.flagMaxImageDim < 0 || flagsCompress.flagMaxVideoHeight < 0 {
+			return fmt.Errorf("--max-image-dimension and --max-video-height can not be negative")
+		}
+		minSize, err := compress.ParseSize(flagsCompress.flagMinSize)
+		if err != nil {
+			return err
+		}
+		assetUUIDs := flagsCompress.flagAssetUUIDs
+		if flagsCompress.flagUUIDFile != "" {
+			uuids, err := readUUIDFile(flagsCompress.flagUUIDFile, cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			assetUUIDs = append(assetUUIDs, uuids...)
+		}
 		config := compress.Config{
-			Parallel:       flagsRoot.flagParallel,
-			Limit:          flagsRoot.flagLimit,
-			AssetType:      flagsCompress.flagAssetType,
-			AssetUUIDs:     flagsCompress.flagAssetUUIDs,
-			Server:         flagsCompress.flagServer,
-			APIKey:         flagsCompress.flagAPIKey,
-			After:          flagsRoot.flagAfter,
-			DiffPercent:    flagsCompress.flagDiff,
-			ImageQuality:   flagsCompress.flagImageQuality,
-			ImageFormat:    (compress.ImageFormat)(strings.ToLower(strings.TrimSpace(flagsCompress.flagImageFormat))),
-			VideoContainer: (compress.VideoContainer)(strings.ToLower(strings.TrimSpace(flagsCompress.flagVideoContainer))),
-			VideoFormat:    (compress.VideoFormat)(strings.ToLower(strings.TrimSpace(flagsCompress.flagVideoFormat))),
-			VideoQuality:   flagsCompress.flagVideoQuality,
+			Parallel:          flagsRoot.flagParallel,
+			Limit:             flagsRoot.flagLimit,
+			Retry:             flagsRoot.flagRetry,
+			AssetType:         flagsCompress.flagAssetType,
+			AssetUUIDs:        assetUUIDs,
+			Server:            flagsCompress.flagServer,
+			APIKey:            flagsCompress.flagAPIKey,
+			After:             flagsRoot.flagAfter,
+			DiffPercent:       flagsCompress.flagDiff,
+			ImageQuality:      flagsCompress.flagImageQuality,
+			ImageFormat:       (compress.ImageFormat)(strings.ToLower(strings.TrimSpace(flagsCompress.flagImageFormat))),
+			ImageMinSSIM:      flagsCompress.flagImageMinSSIM,
+			ImageTargetSSIM:   flagsCompress.flagImageTargetSSIM,
+			ImageMaxDimension: flagsCompress.flagMaxImageDim,
+			ImageAVIF: compress.AVIFOptions{
+				Quality:   flagsCompress.flagAVIFQuality,
+				Effort:    flagsCompress.flagAVIFEffort,
+				Subsample: (compress.AVIFSubsample)(strings.ToLower(strings.TrimSpace(flagsCompress.flagAVIFSubsample))),
+				BitDepth:  flagsCompress.flagAVIFBitDepth,
+			},
+			VideoContainer:  (compress.VideoContainer)(strings.ToLower(strings.TrimSpace(flagsCompress.flagVideoContainer))),
+			VideoFormat:     (compress.VideoFormat)(strings.ToLower(strings.TrimSpace(flagsCompress.flagVideoFormat))),
+			VideoQuality:    flagsCompress.flagVideoQuality,
+			VideoTargetVMAF: flagsCompress.flagVideoTargetVMAF,
+			VideoMinCRF:     flagsCompress.flagVideoMinCRF,
+			VideoMaxCRF:     flagsCompress.flagVideoMaxCRF,
+			VideoMaxHeight:  flagsCompress.flagMaxVideoHeight,
+			VideoMaxFPS:     flagsCompress.flagVideoMaxFPS,
+			AudioTracks:     (compress.AudioTracks)(strings.ToLower(strings.TrimSpace(flagsCompress.flagAudioTracks))),
+			AudioChannels:   flagsCompress.flagAudioChannels,
+			AudioCopy:       flagsCompress.flagAudioCopy,
+			DataStreams:     (compress.DataStreams)(strings.ToLower(strings.TrimSpace(flagsCompress.flagDataStreams))),
+			VideoMinBPP:     flagsCompress.flagVideoMinBPP,
+			VideoHDR:        (compress.HDRMode)(strings.ToLower(strings.TrimSpace(flagsCompress.flagVideoHDR))),
+			DryRun:          flagsCompress.flagDryRun,
+			StateFile:       flagsCompress.flagStateFile,
+			Resume:          flagsCompress.flagResume,
+			DiscardState:    flagsCompress.flagDiscardState,
+			StackPolicy:     (compress.StackPolicy)(strings.ToLower(strings.TrimSpace(flagsCompress.flagStackPolicy))),
+			Selection: compress.Selection{
+				Albums:           flagsCompress.flagAlbums,
+				People:           flagsCompress.flagPeople,
+				Tags:             flagsCompress.flagTags,
+				TakenAfter:       flagsCompress.flagTakenAfter,
+				TakenBefore:      flagsCompress.flagTakenBefore,
+				Make:             flagsCompress.flagCameraMake,
+				Model:            flagsCompress.flagCameraModel,
+				LensModel:        flagsCompress.flagLensModel,
+				City:             flagsCompress.flagCity,
+				State:            flagsCompress.flagState,
+				Country:          flagsCompress.flagCountry,
+				OriginalFileName: flagsCompress.flagFileName,
+				OnlyFavorites:    flagsCompress.flagOnlyFavorites,
+				ExcludeFavorites: flagsCompress.flagExcludeFavorites,
+				NotInAlbum:       flagsCompress.flagNotInAlbum,
+				Visibility:       strings.ToLower(strings.TrimSpace(flagsCompress.flagVisibility)),
+			},
+			MinSize:    minSize,
+			Largest:    flagsCompress.flagLargest,
+			PolicyFile: flagsCompress.flagPolicy,
+			Workers: compress.Workers{
+				Download:     flagsCompress.flagDownloadWorkers,
+				Image:        flagsCompress.flagImageWorkers,
+				Video:        flagsCompress.flagVideoWorkers,
+				Upload:       flagsCompress.flagUploadWorkers,
+				VideoThreads: flagsCompress.flagVideoThreads,
+			},
+			KeepGoing:   flagsCompress.flagKeepGoing,
+			MaxFailures: flagsCompress.flagMaxFailures,
+		}
+		if err := validateAVIF(config.ImageAVIF); err != nil {
+			return err
+		}
+		if err := validateSSIM(config.ImageMinSSIM, config.ImageTargetSSIM); err != nil {
+			return err
 		}
 		return compress.Compressing(cmd.Context(), config)
 	},
@@ -84,12 +296,64 @@ func init() {
 	}
 	compressCmd.PersistentFlags().StringVarP(&flagsCompress.flagAssetType, "type", "i", "ALL", "Asset type to compress (IMAGE, VIDEO, ALL)")
 	compressCmd.PersistentFlags().StringArrayVarP(&flagsCompress.flagAssetUUIDs, "uuid", "u", []string{}, "Asset UUID")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagUUIDFile, "uuid-file", "", "File with one asset UUID per line, - reads from stdin")
 	compressCmd.PersistentFlags().IntVarP(&flagsCompress.flagImageQuality, "image-quality", "q", 80, "Image quality for compression (1-100)")
 	compressCmd.PersistentFlags().StringVarP(&flagsCompress.flagImageFormat, "image-format", "f", string(compress.JXL), fmt.Sprintf("Image format for compression (%v)", strings.Join(formatSlice(compress.ImageFormatsAvailable), ", ")))
+	compressCmd.PersistentFlags().Float64Var(&flagsCompress.flagImageMinSSIM, "image-min-ssim", 0, "Keep the original when the SSIM of the compressed image is lower than this (0-1, 0 disables the check)")
+	compressCmd.PersistentFlags().Float64Var(&flagsCompress.flagImageTargetSSIM, "image-target-ssim", 0, "Search the lowest quality per image that still reaches this SSIM, instead of --image-quality (0-1, 0 disables the search)")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagAVIFQuality, "avif-quality", 0, "Image quality for AVIF (1-100), 0 uses --image-quality")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagAVIFEffort, "avif-effort", 6, fmt.Sprintf("CPU effort of the AVIF encoder (0-%d). Higher is slower and smaller", compress.AVIFEffortMax))
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagAVIFSubsample, "avif-subsample", string(compress.AVIFSubsampleAuto), fmt.Sprintf("Chroma subsampling of AVIF (%v)", strings.Join(formatSlice(compress.AVIFSubsamplesAvailable), ", ")))
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagAVIFBitDepth, "avif-bit-depth", 8, "Bit depth of AVIF (8, 10, 12)")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagMaxImageDim, "max-image-dimension", 0, "Downscale images whose longer side is larger than this many pixels, 0 keeps the resolution")
 	compressCmd.PersistentFlags().IntVarP(&flagsCompress.flagVideoQuality, "video-quality", "Q", 25, "Video quality for compression (1-100). Lower is higher quality")
+	compressCmd.PersistentFlags().Float64Var(&flagsCompress.flagVideoTargetVMAF, "video-target-vmaf", 0, "Pick the highest CRF per video whose sample segments still reach this VMAF score, instead of --video-quality (0-100, 0 disables the search)")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagVideoMinCRF, "video-min-crf", 18, "Lowest CRF tried by --video-target-vmaf")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagVideoMaxCRF, "video-max-crf", 45, "Highest CRF tried by --video-target-vmaf")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagMaxVideoHeight, "max-video-height", 0, "Downscale videos to this height, like 1080, the shorter side for portrait videos. 0 keeps the resolution")
+	compressCmd.PersistentFlags().Float64Var(&flagsCompress.flagVideoMinBPP, "video-min-bpp", 0, "Skip videos that spend fewer bits per pixel and frame, they are efficient already. 0 disables the check")
+	compressCmd.PersistentFlags().Float64Var(&flagsCompress.flagVideoMaxFPS, "video-max-fps", 0, "Lower the frame rate of faster videos to this, like 30. 0 keeps the frame rate")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagVideoHDR, "video-hdr", string(compress.HDRSkip), fmt.Sprintf("What to do with HDR videos when --video-format can not carry HDR, like h264 (%v)", strings.Join(formatSlice(compress.HDRModesAvailable), ", ")))
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagAudioTracks, "audio-tracks", string(compress.AudioTracksFirst), fmt.Sprintf("Audio tracks to keep (%v)", strings.Join(formatSlice(compress.AudioTracksAvailable), ", ")))
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagAudioChannels, "audio-channels", 0, "Downmix audio tracks with more channels, like 2 for stereo. 0 keeps the channels")
+	compressCmd.PersistentFlags().BoolVar(&flagsCompress.flagAudioCopy, "audio-copy", false, "Copy AAC and Opus tracks of at most 160 kbit/s instead of re-encoding them")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagDataStreams, "data-streams", string(compress.DataStreamsDrop), fmt.Sprintf("What to do with data streams like GoPro telemetry or timecode (%v), keep needs --video-container mp4", strings.Join(formatSlice(compress.DataStreamsAvailable), ", ")))
 	compressCmd.PersistentFlags().StringVarP(&flagsCompress.flagVideoFormat, "video-format", "F", string(compress.AV1), fmt.Sprintf("Video format for compression (%v)", strings.Join(formatSlice(compress.VideoFormatsAvailable), ", ")))
 	compressCmd.PersistentFlags().StringVarP(&flagsCompress.flagVideoContainer, "video-container", "C", string(compress.MKV), fmt.Sprintf("Video container format (%v)", strings.Join(formatSlice(compress.VideoContainersAvailable), ", ")))
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagDownloadWorkers, "download-workers", 0, "Parallel downloads, 0 uses --parallel")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagImageWorkers, "image-workers", 0, "Parallel image encodes, 0 uses one per CPU up to --parallel")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagVideoWorkers, "video-workers", 0, "Parallel video encodes, 0 uses one per 8 CPUs up to --parallel")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagUploadWorkers, "upload-workers", 0, "Parallel uploads, 0 uses --parallel")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagVideoThreads, "video-threads", 0, "Threads of one ffmpeg encode, 0 splits the CPUs between the video workers")
+	compressCmd.PersistentFlags().BoolVar(&flagsCompress.flagKeepGoing, "keep-going", false, "Report assets that fail and continue with the next one, instead of stopping the run")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagMaxFailures, "max-failures", 0, "Stop --keep-going after this many failed assets, 0 never stops")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagPolicy, "policy", "", "YAML file with rules that pick the settings per MIME type, extension, camera, size or resolution, or skip assets")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagStackPolicy, "stack-policy", string(compress.StackAll), fmt.Sprintf("Which members of a stack to compress (%v)", strings.Join(formatSlice(compress.StackPoliciesAvailable), ", ")))
+	compressCmd.PersistentFlags().StringArrayVar(&flagsCompress.flagAlbums, "album", []string{}, "Only assets in the album with this name, repeatable")
+	compressCmd.PersistentFlags().StringArrayVar(&flagsCompress.flagPeople, "person", []string{}, "Only assets showing the person with this name, repeatable")
+	compressCmd.PersistentFlags().StringArrayVar(&flagsCompress.flagTags, "tag", []string{}, "Only assets with this tag, by name or full path like Travel/Italy, repeatable")
+	compressCmd.PersistentFlags().TimeVar(&flagsCompress.flagTakenAfter, "taken-after", time.Time{}, []string{"2006-01-02 15:04:05", "2006-01-02"}, "Only assets taken after this time")
+	compressCmd.PersistentFlags().TimeVar(&flagsCompress.flagTakenBefore, "taken-before", time.Time{}, []string{"2006-01-02 15:04:05", "2006-01-02"}, "Only assets taken before this time")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagCameraMake, "camera-make", "", "Only assets taken with a camera of this make")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagCameraModel, "camera-model", "", "Only assets taken with this camera model")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagLensModel, "lens-model", "", "Only assets taken with this lens")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagCity, "city", "", "Only assets taken in this city")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagState, "region", "", "Only assets taken in this state or region")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagCountry, "country", "", "Only assets taken in this country")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagFileName, "file-name", "", "Only assets whose original file name contains this")
+	compressCmd.PersistentFlags().BoolVar(&flagsCompress.flagOnlyFavorites, "only-favorites", false, "Only favorite assets")
+	compressCmd.PersistentFlags().BoolVar(&flagsCompress.flagExcludeFavorites, "exclude-favorites", false, "Leave favorite assets untouched")
+	compressCmd.PersistentFlags().BoolVar(&flagsCompress.flagNotInAlbum, "not-in-album", false, "Only assets that are in no album")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagVisibility, "visibility", "", "Only assets with this visibility (archive, hidden, locked, timeline)")
+	compressCmd.MarkFlagsMutuallyExclusive("only-favorites", "exclude-favorites")
+	compressCmd.MarkFlagsMutuallyExclusive("album", "not-in-album")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagMinSize, "min-size", "", "Only assets of at least this size, like 500K, 20M or 1G, largest first")
+	compressCmd.PersistentFlags().IntVar(&flagsCompress.flagLargest, "largest", 0, "Only the N largest assets, largest first (at most 1000)")
 	compressCmd.PersistentFlags().IntVarP(&flagsCompress.flagDiff, "diff-percents", "D", 8, "If size diff is lower than this percent files will not be replaced with new.")
+	compressCmd.PersistentFlags().BoolVarP(&flagsCompress.flagDryRun, "dry-run", "n", false, "Download and compress assets, print what would be replaced and the projected savings, but do not change anything on the server")
+	compressCmd.PersistentFlags().StringVar(&flagsCompress.flagStateFile, "state", defaultStateFile(), "File that records the progress of every asset, empty to disable")
+	compressCmd.PersistentFlags().BoolVarP(&flagsCompress.flagResume, "resume", "r", false, "Continue an interrupted run from the state file: skip finished assets and complete half-done replacements")
+	compressCmd.PersistentFlags().BoolVar(&flagsCompress.flagDiscardState, "discard-state", false, "Start over although the state file has uploaded but unfinished assets, their half-done replacements are not completed")
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
@@ -3,10 +3,15 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"immich-compress/compress"
+
 	"github.com/spf13/cobra"
 )
 
@@ -56,6 +61,330 @@ func TestCompressCommandHasRequiredFlags(t *testing.T) {
 	}
 }
 
+// TestCompressCommandDryRunFlag verifies the dry-run flag is defined and off by default
+func TestCompressCommandDryRunFlag(t *testing.T) {
+	dryRunFlag := compressCmd.PersistentFlags().Lookup("dry-run")
+	if dryRunFlag == nil {
+		t.Fatal("dry-run flag should be defined")
+	}
+	if dryRunFlag.Shorthand != "n" {
+		t.Errorf("Expected dry-run flag shorthand 'n', got %q", dryRunFlag.Shorthand)
+	}
+	if dryRunFlag.DefValue != "false" {
+		t.Errorf("Expected dry-run to default to false, got %q", dryRunFlag.DefValue)
+	}
+}
+
+// TestCompressCommandResumeFlags verifies the state file and resume flags are defined
+func TestCompressCommandResumeFlags(t *testing.T) {
+	stateFlag := compressCmd.PersistentFlags().Lookup("state")
+	if stateFlag == nil {
+		t.Fatal("state flag should be defined")
+	}
+	if stateFlag.DefValue != defaultStateFile() {
+		t.Errorf("Expected state default %q, got %q", defaultStateFile(), stateFlag.DefValue)
+	}
+
+	resumeFlag := compressCmd.PersistentFlags().Lookup("resume")
+	if resumeFlag == nil {
+		t.Fatal("resume flag should be defined")
+	}
+	if resumeFlag.DefValue != "false" {
+		t.Errorf("Expected resume to default to false, got %q", resumeFlag.DefValue)
+	}
+
+	discardFlag := compressCmd.PersistentFlags().Lookup("discard-state")
+	if discardFlag == nil {
+		t.Fatal("discard-state flag should be defined")
+	}
+	if discardFlag.DefValue != "false" {
+		t.Errorf("Expected discard-state to default to false, got %q", discardFlag.DefValue)
+	}
+}
+
+// TestCompressCommandImageMinSSIMFlag verifies the quality gate is disabled by default
+func TestCompressCommandImageMinSSIMFlag(t *testing.T) {
+	flag := compressCmd.PersistentFlags().Lookup("image-min-ssim")
+	if flag == nil {
+		t.Fatal("image-min-ssim flag should be defined")
+	}
+	if flag.DefValue != "0" {
+		t.Errorf("Expected image-min-ssim to default to 0, got %q", flag.DefValue)
+	}
+}
+
+// TestCompressCommandImageTargetSSIMFlag verifies the quality search is disabled by default
+func TestCompressCommandImageTargetSSIMFlag(t *testing.T) {
+	flag := compressCmd.PersistentFlags().Lookup("image-target-ssim")
+	if flag == nil {
+		t.Fatal("image-target-ssim flag should be defined")
+	}
+	if flag.DefValue != "0" {
+		t.Errorf("Expected image-target-ssim to default to 0, got %q", flag.DefValue)
+	}
+}
+
+// TestCompressCommandVideoVMAFFlags verifies the CRF search flags and their defaults
+func TestCompressCommandVideoVMAFFlags(t *testing.T) {
+	expected := map[string]string{
+		"video-target-vmaf": "0",
+		"video-min-crf":     "18",
+		"video-max-crf":     "45",
+	}
+	for name, def := range expected {
+		flag := compressCmd.PersistentFlags().Lookup(name)
+		if flag == nil {
+			t.Errorf("%s flag should be defined", name)
+			continue
+		}
+		if flag.DefValue != def {
+			t.Errorf("Expected %s to default to %s, got %q", name, def, flag.DefValue)
+		}
+	}
+}
+
+// TestCompressCommandStackPolicyFlag verifies all stack members are compressed by default
+func TestCompressCommandStackPolicyFlag(t *testing.T) {
+	flag := compressCmd.PersistentFlags().Lookup("stack-policy")
+	if flag == nil {
+		t.Fatal("stack-policy flag should be defined")
+	}
+	if flag.DefValue != "all" {
+		t.Errorf("Expected stack-policy to default to all, got %q", flag.DefValue)
+	}
+}
+
+// TestCompressCommandAVIFFlags verifies the AVIF options and their defaults
+func TestCompressCommandAVIFFlags(t *testing.T) {
+	defaults := map[string]string{
+		"avif-quality":   "0",
+		"avif-effort":    "6",
+		"avif-subsample": "auto",
+		"avif-bit-depth": "8",
+	}
+	for name, def := range defaults {
+		flag := compressCmd.PersistentFlags().Lookup(name)
+		if flag == nil {
+			t.Errorf("%s flag should be defined", name)
+			continue
+		}
+		if flag.DefValue != def {
+			t.Errorf("Expected %s to default to %s, got %q", name, def, flag.DefValue)
+		}
+	}
+}
+
+// TestValidateAVIF verifies out of range AVIF options are rejected
+func TestValidateAVIF(t *testing.T) {
+	valid := compress.AVIFOptions{Quality: 0, Effort: 6, Subsample: compress.AVIFSubsampleAuto, BitDepth: 8}
+	tests := []struct {
+		name    string
+		modify  func(*compress.AVIFOptions)
+		wantErr bool
+	}{
+		{name: "defaults", modify: func(o *compress.AVIFOptions) {}},
+		{name: "10 bit 444", modify: func(o *compress.AVIFOptions) { o.BitDepth = 10; o.Subsample = compress.AVIFSubsample444 }},
+		{name: "quality too high", modify: func(o *compress.AVIFOptions) { o.Quality = 101 }, wantErr: true},
+		{name: "negative quality", modify: func(o *compress.AVIFOptions) { o.Quality = -1 }, wantErr: true},
+		{name: "effort too high", modify: func(o *compress.AVIFOptions) { o.Effort = 10 }, wantErr: true},
+		{name: "unknown subsample", modify: func(o *compress.AVIFOptions) { o.Subsample = "422" }, wantErr: true},
+		{name: "unsupported bit depth", modify: func(o *compress.AVIFOptions) { o.BitDepth = 16 }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := valid
+			tt.modify(&options)
+			err := validateAVIF(options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAVIF() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSSIM(t *testing.T) {
+	tests := []struct {
+		name        string
+		min, target float64
+		wantErr     bool
+	}{
+		{name: "disabled", min: 0, target: 0},
+		{name: "min only", min: 0.95},
+		{name: "target above min", min: 0.9, target: 0.95},
+		{name: "identical only", min: 1, target: 1},
+		{name: "min as percent", min: 95, wantErr: true},
+		{name: "negative target", target: -1, wantErr: true},
+		{name: "target as percent", target: 95, wantErr: true},
+		{name: "target below min", min: 0.95, target: 0.9, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSSIM(tt.min, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSSIM() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCompressCommandResolutionFlags verifies the resolution is kept by default
+func TestCompressCommandResolutionFlags(t *testing.T) {
+	for _, name := range []string{"max-image-dimension", "max-video-height"} {
+		flag := compressCmd.PersistentFlags().Lookup(name)
+		if flag == nil {
+			t.Errorf("%s flag should be defined", name)
+			continue
+		}
+		if flag.DefValue != "0" {
+			t.Errorf("Expected %s to default to 0, got %q", name, flag.DefValue)
+		}
+	}
+}
+
+// TestCompressCommandStreamFlags verifies the frame rate and stream options
+// keep what ffmpeg selects by default
+func TestCompressCommandStreamFlags(t *testing.T) {
+	defaults := map[string]string{
+		"video-max-fps":  "0",
+		"audio-tracks":   "first",
+		"audio-channels": "0",
+		"audio-copy":     "false",
+		"data-streams":   "drop",
+		"video-min-bpp":  "0",
+		"video-hdr":      "skip",
+	}
+	for name, def := range defaults {
+		flag := compressCmd.PersistentFlags().Lookup(name)
+		if flag == nil {
+			t.Errorf("%s flag should be defined", name)
+			continue
+		}
+		if flag.DefValue != def {
+			t.Errorf("Expected %s to default to %s, got %q", name, def, flag.DefValue)
+		}
+	}
+}
+
+// TestCompressCommandWorkerFlags verifies the worker limits default to automatic
+func TestCompressCommandWorkerFlags(t *testing.T) {
+	for _, name := range []string{"download-workers", "image-workers", "video-workers", "upload-workers", "video-threads"} {
+		flag := compressCmd.PersistentFlags().Lookup(name)
+		if flag == nil {
+			t.Errorf("%s flag should be defined", name)
+			continue
+		}
+		if flag.DefValue != "0" {
+			t.Errorf("Expected %s to default to 0, got %q", name, flag.DefValue)
+		}
+	}
+}
+
+// TestCompressCommandKeepGoingFlags verifies a failed asset stops the run by default
+func TestCompressCommandKeepGoingFlags(t *testing.T) {
+	for name, def := range map[string]string{"keep-going": "false", "max-failures": "0"} {
+		flag := compressCmd.PersistentFlags().Lookup(name)
+		if flag == nil {
+			t.Errorf("%s flag should be defined", name)
+			continue
+		}
+		if flag.DefValue != def {
+			t.Errorf("Expected %s to default to %s, got %q", name, def, flag.DefValue)
+		}
+	}
+}
+
+// TestCompressCommandVideoTargetVMAFRange verifies the VMAF target is
+// checked before the run starts
+func TestCompressCommandVideoTargetVMAFRange(t *testing.T) {
+	saved := flagsCompress
+	defer func() { flagsCompress = saved }()
+
+	for _, vmaf := range []float64{-1, 101} {
+		flagsCompress.flagVideoTargetVMAF = vmaf
+		err := compressCmd.RunE(compressCmd, nil)
+		if err == nil || !strings.Contains(err.Error(), "--video-target-vmaf") {
+			t.Errorf("Expected --video-target-vmaf %g to be rejected, got %v", vmaf, err)
+		}
+	}
+}
+
+// TestCompressCommandMaxFailuresNeedsKeepGoing verifies --max-failures is
+// not ignored silently
+func TestCompressCommandMaxFailuresNeedsKeepGoing(t *testing.T) {
+	saved := flagsCompress
+	defer func() { flagsCompress = saved }()
+	flagsCompress.flagMaxFailures = 3
+	flagsCompress.flagKeepGoing = false
+
+	err := compressCmd.RunE(compressCmd, nil)
+	if err == nil || !strings.Contains(err.Error(), "--keep-going") {
+		t.Errorf("Expected --max-failures to need --keep-going, got %v", err)
+	}
+}
+
+// TestCompressCommandPolicyFlag verifies no policy file is used by default
+func TestCompressCommandPolicyFlag(t *testing.T) {
+	flag := compressCmd.PersistentFlags().Lookup("policy")
+	if flag == nil {
+		t.Fatal("policy flag should be defined")
+	}
+	if flag.DefValue != "" {
+		t.Errorf("Expected policy to default to empty, got %q", flag.DefValue)
+	}
+}
+
+// TestCompressCommandSelectionFlags verifies the asset selection flags exist
+func TestCompressCommandSelectionFlags(t *testing.T) {
+	flags := []string{
+		"album", "person", "tag", "taken-after", "taken-before",
+		"camera-make", "camera-model", "lens-model", "city", "region", "country",
+		"file-name", "only-favorites", "exclude-favorites", "not-in-album", "visibility",
+	}
+	for _, name := range flags {
+		if compressCmd.PersistentFlags().Lookup(name) == nil {
+			t.Errorf("%s flag should be defined", name)
+		}
+	}
+}
+
+// TestReadUUIDFile verifies UUIDs are read from files and stdin
+func TestReadUUIDFile(t *testing.T) {
+	content := "# assets to compress\n0f8fad5b-d9cb-469f-a165-70867728950e\n\n  7c9e6679-7425-40de-944b-e07fc1f90ae7  \n"
+	expected := []string{"0f8fad5b-d9cb-469f-a165-70867728950e", "7c9e6679-7425-40de-944b-e07fc1f90ae7"}
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "uuids.txt")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		uuids, err := readUUIDFile(path, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !slices.Equal(uuids, expected) {
+			t.Errorf("Expected %v, got %v", expected, uuids)
+		}
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		uuids, err := readUUIDFile("-", strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !slices.Equal(uuids, expected) {
+			t.Errorf("Expected %v, got %v", expected, uuids)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := readUUIDFile(filepath.Join(t.TempDir(), "missing.txt"), nil); err == nil {
+			t.Error("Expected error for missing file, got nil")
+		}
+	})
+}
+
 // TestCompressCommandRequiredFlagsValidation tests that required flags work with Cobra
 func TestCompressCommandRequiredFlagsValidation(t *testing.T) {
 	if compressCmd == nil {
@@ -396,6 +725,20 @@ func TestCompressCommandIntegration(t *testing.T) {
 	})
 }
 
+// TestRootRetryFlags verifies the retry settings shared by compress and rollback
+func TestRootRetryFlags(t *testing.T) {
+	for name, def := range map[string]string{"retries": "4", "retry-min-delay": "1s", "retry-max-delay": "30s"} {
+		flag := rootCmd.PersistentFlags().Lookup(name)
+		if flag == nil {
+			t.Errorf("%s flag should be defined on root command", name)
+			continue
+		}
+		if flag.DefValue != def {
+			t.Errorf("Expected %s to default to %s, got %q", name, def, flag.DefValue)
+		}
+	}
+}
+
 // TestCompressCommandParallelAndAfterFlags tests integration with root command flags
 func TestCompressCommandParallelAndAfterFlags(t *testing.T) {
 	if rootCmd == nil {
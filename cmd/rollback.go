@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"time"
+
+	"immich-compress/compress"
+
+	"github.com/spf13/cobra"
+)
+
+var flagsRollback struct {
+	flagServer string
+	flagAPIKey string
+	flagRunID  string
+	flagSince  time.Time
+	flagUntil  time.Time
+}
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore originals of compressed fotos/videos",
+	Long: `Undo a compression run: restore the originals from the trash, copy albums, favorites and tags back
+and move the compressed copies to the trash. Select the run by its ID (printed by compress) or by the time window
+in which the compressed copies were uploaded.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := compress.RollbackConfig{
+			Parallel: flagsRoot.flagParallel,
+			Server:   flagsRollback.flagServer,
+			APIKey:   flagsRollback.flagAPIKey,
+			RunID:    flagsRollback.flagRunID,
+			Since:    flagsRollback.flagSince,
+			Until:    flagsRollback.flagUntil,
+			Retry:    flagsRoot.flagRetry,
+		}
+		return compress.Rollback(cmd.Context(), config)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.PersistentFlags().StringVarP(&flagsRollback.flagServer, "server", "s", "", "The immich server address")
+	if err := rollbackCmd.MarkPersistentFlagRequired("server"); err != nil {
+		panic(err)
+	}
+	rollbackCmd.PersistentFlags().StringVarP(&flagsRollback.flagAPIKey, "api-key", "a", "", "The immich server API key")
+	if err := rollbackCmd.MarkPersistentFlagRequired("api-key"); err != nil {
+		panic(err)
+	}
+	rollbackCmd.PersistentFlags().StringVarP(&flagsRollback.flagRunID, "run", "r", "", "ID of the run to roll back")
+	rollbackCmd.PersistentFlags().TimeVar(&flagsRollback.flagSince, "since", time.Time{}, []string{"2006-01-02 15:04:05"}, "Roll back assets compressed after this time")
+	rollbackCmd.PersistentFlags().TimeVar(&flagsRollback.flagUntil, "until", time.Time{}, []string{"2006-01-02 15:04:05"}, "Roll back assets compressed before this time")
+	rollbackCmd.MarkFlagsOneRequired("run", "since", "until")
+}
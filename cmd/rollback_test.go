@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+)
+
+// TestRollbackCommandExists verifies the rollback command is properly defined
+func TestRollbackCommandExists(t *testing.T) {
+	if rollbackCmd == nil {
+		t.Fatal("rollbackCmd should not be nil")
+	}
+
+	if rollbackCmd.Use != "rollback" {
+		t.Errorf("Expected command use 'rollback', got %q", rollbackCmd.Use)
+	}
+
+	if rollbackCmd.RunE == nil {
+		t.Error("rollback command should have a RunE function")
+	}
+
+	found := false
+	for _, cmd := range rootCmd.Commands() {
+		if cmd == rollbackCmd {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("rollback command should be registered on the root command")
+	}
+}
+
+// TestRollbackCommandFlags verifies the selection flags are present
+func TestRollbackCommandFlags(t *testing.T) {
+	for _, name := range []string{"server", "api-key", "run", "since", "until"} {
+		if rollbackCmd.PersistentFlags().Lookup(name) == nil {
+			t.Errorf("%s flag should be defined", name)
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"runtime"
 	"time"
 
+	"immich-compress/immich"
+
 	"github.com/spf13/cobra"
 )
 
@@ -13,6 +15,7 @@ var flagsRoot struct {
 	flagParallel int
 	flagAfter    time.Time
 	flagLimit    int
+	flagRetry    immich.Retry
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -45,6 +48,9 @@ func init() {
 	rootCmd.PersistentFlags().IntVarP(&flagsRoot.flagParallel, "parallel", "p", runtime.NumCPU(), "parallel")
 	rootCmd.PersistentFlags().TimeVarP(&flagsRoot.flagAfter, "after", "t", time.Now(), []string{"2006-01-02 15:04:05"}, "after what time we want to recompress")
 	rootCmd.PersistentFlags().IntVarP(&flagsRoot.flagLimit, "limit", "l", 0, "maximum number of assets to compress")
+	rootCmd.PersistentFlags().IntVar(&flagsRoot.flagRetry.Retries, "retries", 4, "Retries of an Immich request that failed with a network error, 429 or 5xx, 0 disables retrying")
+	rootCmd.PersistentFlags().DurationVar(&flagsRoot.flagRetry.MinDelay, "retry-min-delay", time.Second, "Pause before the first retry, it doubles with every further one")
+	rootCmd.PersistentFlags().DurationVar(&flagsRoot.flagRetry.MaxDelay, "retry-max-delay", 30*time.Second, "Longest pause between retries, a longer Retry-After of the server ends retrying")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,72 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"immich-compress/immich"
+)
+
+// failure is an asset that failed while the run kept going
+type failure struct {
+	fileName string
+	assetID  string
+	stage    string
+	err      error
+}
+
+// failures collects the failed assets of a run with --keep-going. Max is
+// the number of failures that stops the run, 0 never stops it.
+type failures struct {
+	max  int
+	mu   sync.Mutex
+	list []failure
+}
+
+// handle decides about the error of an asset. Without --keep-going (nil
+// failures) or once the run is cancelled the error stops the run, otherwise
+// it is recorded.
+func (f *failures) handle(ctx context.Context, asset immich.AssetResponseDto, stage string, err error) error {
+	if f == nil || ctx.Err() != nil {
+		return err
+	}
+	return f.add(asset, stage, err)
+}
+
+// add records the failure of an asset. It returns an error once the budget
+// is used up, which stops the run.
+func (f *failures) add(asset immich.AssetResponseDto, stage string, err error) error {
+	var staged *stageError
+	if errors.As(err, &staged) {
+		stage = staged.stage.String()
+	}
+	fmt.Printf("✗ Failed: %s (%s: %v)\n", asset.OriginalFileName, stage, err)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.list = append(f.list, failure{fileName: asset.OriginalFileName, assetID: asset.Id, stage: stage, err: err})
+	if f.max > 0 && len(f.list) >= f.max {
+		return fmt.Errorf("stopped after %d failures, the last one: %s: %w", len(f.list), asset.OriginalFileName, err)
+	}
+	return nil
+}
+
+// report prints every failure and returns an error when there was one, so
+// the run exits with a non-zero code
+func (f *failures) report() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.list) == 0 {
+		return nil
+	}
+	fmt.Printf("Failed files: %d\n", len(f.list))
+	for _, failure := range f.list {
+		fmt.Printf("  %s %s (%s): %v\n", failure.assetID, failure.fileName, failure.stage, failure.err)
+	}
+	return fmt.Errorf("%d assets failed", len(f.list))
+}
@@ -0,0 +1,69 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"immich-compress/immich"
+)
+
+func TestFailuresHandle(t *testing.T) {
+	asset := immich.AssetResponseDto{Id: "id-1", OriginalFileName: "a.jpg"}
+	errFailed := errors.New("failed")
+
+	var none *failures
+	if err := none.handle(context.Background(), asset, "replace", errFailed); err != errFailed {
+		t.Errorf("Expected the error without --keep-going, got %v", err)
+	}
+
+	f := &failures{}
+	if err := f.handle(context.Background(), asset, "replace", errFailed); err != nil {
+		t.Errorf("Expected the failure to be recorded, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := f.handle(ctx, asset, "replace", context.Canceled); err != context.Canceled {
+		t.Errorf("Expected the cancellation to stop the run, got %v", err)
+	}
+	if len(f.list) != 1 {
+		t.Errorf("Expected 1 failure, got %d", len(f.list))
+	}
+}
+
+func TestFailuresAddStage(t *testing.T) {
+	f := &failures{}
+	asset := immich.AssetResponseDto{Id: "id-1", OriginalFileName: "a.mp4"}
+	_ = f.add(asset, "replace", &stageError{stage: stageUpload, err: errors.New("bad status code")})
+	_ = f.add(asset, "resume", errors.New("not found"))
+	if f.list[0].stage != "upload" || f.list[1].stage != "resume" {
+		t.Errorf("Expected stages upload and resume, got %+v", f.list)
+	}
+}
+
+func TestFailuresBudget(t *testing.T) {
+	f := &failures{max: 2}
+	asset := immich.AssetResponseDto{Id: "id-1", OriginalFileName: "a.jpg"}
+	errFailed := errors.New("failed")
+	if err := f.add(asset, "replace", errFailed); err != nil {
+		t.Errorf("Expected the first failure to keep going, got %v", err)
+	}
+	if err := f.add(asset, "replace", errFailed); !errors.Is(err, errFailed) {
+		t.Errorf("Expected the second failure to stop the run, got %v", err)
+	}
+}
+
+func TestFailuresReport(t *testing.T) {
+	var none *failures
+	if err := none.report(); err != nil {
+		t.Errorf("Expected no error without --keep-going, got %v", err)
+	}
+	f := &failures{}
+	if err := f.report(); err != nil {
+		t.Errorf("Expected no error without failures, got %v", err)
+	}
+	_ = f.add(immich.AssetResponseDto{Id: "id-1", OriginalFileName: "a.jpg"}, "replace", errors.New("failed"))
+	if err := f.report(); err == nil {
+		t.Error("Expected an error after a failure")
+	}
+}
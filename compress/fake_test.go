@@ -0,0 +1,38 @@
+package compress
+
+import (
+	"context"
+	"testing"
+
+	"immich-compress/immich"
+	"immich-compress/immich/immichtest"
+)
+
+// fakeServer is the shared fake Immich server with a client of this package
+type fakeServer struct {
+	*immichtest.Server
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	return &fakeServer{immichtest.NewServer(t)}
+}
+
+// client returns a client of the fake server without retries
+func (f *fakeServer) client(t *testing.T) *immich.ClientSimple {
+	t.Helper()
+	client, err := immich.NewClientSimple(context.Background(), 2, f.URL, "key", immich.Retry{})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client
+}
+
+// searchResult is the answer of the metadata search with a single page
+func searchResult(assets ...immich.AssetResponseDto) immich.SearchResponseDto {
+	var result immich.SearchResponseDto
+	result.Assets.Items = assets
+	result.Assets.Count = len(assets)
+	result.Assets.Total = len(assets)
+	return result
+}
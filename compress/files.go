@@ -2,80 +2,459 @@ package compress
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"immich-compress/immich"
 
+	"github.com/google/uuid"
 	"github.com/oapi-codegen/runtime/types"
 )
 
+// skipError rejects a compressed asset without failing the run
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+func skipAsset(format string, args ...any) error {
+	return &skipError{reason: fmt.Sprintf(format, args...)}
+}
+
+// compressed is the output of a compressor
+type compressed struct {
+	file *os.File
+	// quality names the encoder setting picked for this asset, empty when
+	// the configured one was used
+	quality string
+}
+
 type compress interface {
-	compress(ctx context.Context, client *immich.ClientSimple, asset immich.AssetResponseDto) (*os.File, error)
+	compress(ctx context.Context, asset immich.AssetResponseDto, fileIn string) (*compressed, error)
 }
 
-func compressFile(ctx context.Context, client *immich.ClientSimple, asset immich.AssetResponseDto, diffPercent int, imageConfig ImageConfig, videoConfig VideoConfig) error {
-	skipped := true
-	sizeOrig := *asset.ExifInfo.FileSizeInByte
-	var sizeNew int64
+// compressFile compresses a single asset and replaces it on the server when
+// the size reduction is big enough. The still of a Live Photo is handled
+// together with its motion video, a motion video that is skipped is kept
+// and the compressed still linked to it. It returns the number of bytes saved, or
+// the projected saving when dryRun is set. Downloads, encodes and uploads
+// wait for a free worker of their stage.
+func compressFile(ctx context.Context, client *immich.ClientSimple, journal *journal, stages *stages, asset immich.AssetResponseDto, diffPercent int, dryRun bool, imageConfig ImageConfig, videoConfig VideoConfig) (int64, error) {
+	if asset.Type == immich.VIDEO && asset.Visibility == immich.Hidden {
+		// Motion videos of Live Photos are compressed with their still
+		fmt.Printf("✗ Skipped: %s (hidden video, handled with its Live Photo)\n", asset.OriginalFileName)
+		return 0, nil
+	}
+	parts, err := livePhotoParts(client, asset)
+	if err != nil {
+		return 0, err
+	}
+
+	var sizeOrig, sizeNew int64
+	results := make([]*compressed, 0, len(parts))
+	defer func() {
+		for _, result := range results {
+			result.file.Close()
+			os.Remove(result.file.Name())
+		}
+	}()
+	// encoded are the parts with a result, in the order of results
+	encoded := make([]immich.AssetResponseDto, 0, len(parts))
+	for i, part := range parts {
+		result, err := encodeFile(ctx, client, journal, stages, part, dryRun, &imageConfig, &videoConfig)
+		var skip *skipError
+		if errors.As(err, &skip) && i < len(parts)-1 {
+			// A skipped motion video is kept, the still is compressed anyway
+			if !dryRun {
+				if err := journal.record(part.Id, StageSkipped, ""); err != nil {
+					return 0, err
+				}
+			}
+			fmt.Printf("✗ Skipped: %s (%s, kept for %s)\n", part.OriginalFileName, skip.reason, asset.OriginalFileName)
+			continue
+		}
+		if errors.As(err, &skip) {
+			if !dryRun {
+				if err := recordParts(journal, parts, StageSkipped); err != nil {
+					return 0, err
+				}
+			}
+			fmt.Printf("✗ Skipped: %s (%s)\n", asset.OriginalFileName, skip.reason)
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		results = append(results, result)
+		encoded = append(encoded, part)
+
+		fileInfo, err := result.file.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("error getting file stats: %w", err)
+		}
+
+		// 3. Get the size from the FileInfo
+		if fileInfo.Size() == 0 {
+			return 0, fmt.Errorf("compressed size is 0 most likely we have an error")
+		}
+		sizeOrig += *part.ExifInfo.FileSizeInByte
+		sizeNew += fileInfo.Size()
+	}
+
+	sizeOrigMB := bytesToMB(sizeOrig)
+	sizeNewMB := bytesToMB(sizeNew)
+	sizeSavedMB := bytesToMB(sizeOrig - sizeNew)
+
+	replace := shouldReplace(sizeOrig, sizeNew, diffPercent)
+	if replace && dryRun {
+		// Stop before anything is changed on the server
+		fmt.Printf("~ Would replace: %s (Original: %.2f MB, Converted: %.2f MB, Saves: %.2f MB)\n", asset.OriginalFileName, sizeOrigMB, sizeNewMB, sizeSavedMB)
+		return sizeOrig - sizeNew, nil
+	}
+	if !replace {
+		if !dryRun {
+			if err := recordParts(journal, parts, StageSkipped); err != nil {
+				return 0, err
+			}
+		}
+		fmt.Printf("✗ Skipped: %s (Original: %.2f MB, Converted: %.2f MB, No size reduction)\n", asset.OriginalFileName, sizeOrigMB, sizeNewMB)
+		return 0, nil
+	}
+	if err := recordParts(journal, encoded, StageEncoded); err != nil {
+		return 0, err
+	}
+
+	replacements, err := uploadParts(ctx, client, journal, stages, encoded, results)
+	if err != nil {
+		return 0, err
+	}
+	err = finishReplace(client, journal, replacements)
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("✓ Replaced: %s (Original: %.2f MB, Converted: %.2f MB, Saved: %.2f MB)\n", asset.OriginalFileName, sizeOrigMB, sizeNewMB, sizeSavedMB)
+
+	return sizeOrig - sizeNew, nil
+}
+
+// uploadParts uploads the compressed copies of the parts, results holds the
+// copy of every part. The motion video goes first, so the new still can be
+// linked to it.
+func uploadParts(ctx context.Context, client *immich.ClientSimple, journal *journal, stages *stages, parts []immich.AssetResponseDto, results []*compressed) ([]replacement, error) {
+	replacements := make([]replacement, 0, len(parts))
+	for i, part := range parts {
+		part = linkMotion(part, replacements)
+		var uuidNew *types.UUID
+		err := stages.run(ctx, stageUpload, func() (err error) {
+			uuidNew, err = uploadFile(client, part, results[i].file)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		// Rollback finds the original through this record, a reused
+		// duplicate keeps the device ids of another asset
+		err = client.AssetCompressRecord(*uuidNew, part.Id, results[i].quality)
+		if err != nil {
+			return nil, err
+		}
+		if err := journal.record(part.Id, StageUploaded, uuidNew.String()); err != nil {
+			return nil, err
+		}
+		replacements = append(replacements, replacement{assetID: part.Id, newID: *uuidNew, from: StageUploaded})
+	}
+	return replacements, nil
+}
+
+// linkMotion points the still of a Live Photo to the compressed copy of its
+// motion video. A still whose motion video was kept stays linked to it,
+// Immich keeps a motion video as long as a still refers to it.
+func linkMotion(still immich.AssetResponseDto, replacements []replacement) immich.AssetResponseDto {
+	if still.LivePhotoVideoId == nil {
+		return still
+	}
+	for _, r := range replacements {
+		if r.assetID == *still.LivePhotoVideoId {
+			linked := r.newID.String()
+			still.LivePhotoVideoId = &linked
+		}
+	}
+	return still
+}
+
+// livePhotoParts returns the assets that are replaced as one unit: the
+// motion video followed by the still for a Live Photo, the asset otherwise
+func livePhotoParts(client *immich.ClientSimple, asset immich.AssetResponseDto) ([]immich.AssetResponseDto, error) {
+	if asset.Type != immich.IMAGE || asset.LivePhotoVideoId == nil {
+		return []immich.AssetResponseDto{asset}, nil
+	}
+	motionID, err := immich.UUUIDOfString(*asset.LivePhotoVideoId)
+	if err != nil {
+		return nil, err
+	}
+	motion, err := client.AssetInfo(motionID)
+	if err != nil {
+		return nil, fmt.Errorf("can not get motion video of '%s': %w", asset.OriginalFileName, err)
+	}
+	return []immich.AssetResponseDto{*motion, asset}, nil
+}
+
+// encodeFile downloads an asset and encodes it with the compressor of its
+// type
+func encodeFile(ctx context.Context, client *immich.ClientSimple, journal *journal, stages *stages, asset immich.AssetResponseDto, dryRun bool, imageConfig *ImageConfig, videoConfig *VideoConfig) (*compressed, error) {
 	var compress compress
+	var encodeStage stage
 	switch asset.Type {
 	case "IMAGE":
-		compress = &imageConfig
+		compress = imageConfig
+		encodeStage = stageImage
 	case "VIDEO":
-		compress = &videoConfig
+		compress = videoConfig
+		encodeStage = stageVideo
 	default:
-		return fmt.Errorf("we do not support type: %s", asset.Type)
+		return nil, fmt.Errorf("we do not support type: %s", asset.Type)
 	}
 
-	file, err := compress.compress(ctx, client, asset)
-	if err != nil {
+	var fileIn string
+	err := stages.run(ctx, stageDownload, func() (err error) {
+		fileIn, err = downloadFile(client, asset)
 		return err
-	}
-	defer os.Remove(file.Name())
-
-	fileInfo, err := file.Stat()
+	})
 	if err != nil {
-		return fmt.Errorf("error getting file stats: %w", err)
+		return nil, err
+	}
+	defer os.Remove(fileIn)
+	if !dryRun {
+		if err := journal.record(asset.Id, StageDownloaded, ""); err != nil {
+			return nil, err
+		}
 	}
 
-	// 3. Get the size from the FileInfo
-	sizeNew = fileInfo.Size()
-	if sizeNew == 0 {
-		return fmt.Errorf("compressed size is 0 most likely we have an error")
+	var result *compressed
+	err = stages.run(ctx, encodeStage, func() (err error) {
+		result, err = compress.compress(ctx, asset, fileIn)
+		return err
+	})
+	return result, err
+}
+
+// recordParts records the same stage for every part of a unit
+func recordParts(journal *journal, parts []immich.AssetResponseDto, stage Stage) error {
+	for _, part := range parts {
+		if err := journal.record(part.Id, stage, ""); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// replacement is an uploaded compressed copy that has not replaced its
+// original yet
+type replacement struct {
+	assetID string
+	newID   types.UUID
+	from    Stage
+}
 
-	var uuidNew *types.UUID
-	if sizeOrig-sizeNew > int64(float64(sizeOrig)*(float64(diffPercent)/100)) {
-		uuidNew, err = uploadFile(client, asset, file)
+// finishReplace runs the steps after the upload of the compressed copies:
+// tag the new assets and move the originals to the trash together. Starting
+// from StageTagged only the original is removed.
+func finishReplace(client *immich.ClientSimple, journal *journal, replacements []replacement) error {
+	uuidsOrig := make([]types.UUID, 0, len(replacements))
+	for _, r := range replacements {
+		if r.from == StageUploaded {
+			err := client.TagCompressedAdd(r.newID)
+			if err != nil {
+				return err
+			}
+			if err := journal.record(r.assetID, StageTagged, ""); err != nil {
+				return err
+			}
+		}
+
+		uuidOrig, err := immich.UUUIDOfString(r.assetID)
 		if err != nil {
 			return err
 		}
-		err = client.TagCompressedAdd(*uuidNew)
-		if err != nil {
+		uuidsOrig = append(uuidsOrig, uuidOrig)
+	}
+
+	err := client.AssetDeleteMultiple(uuidsOrig, false)
+	if err != nil {
+		return fmt.Errorf("can not delete original: %w", err)
+	}
+	for _, r := range replacements {
+		if err := journal.record(r.assetID, StageDeleted, ""); err != nil {
 			return err
 		}
-		client.AssetDelete(*uuidNew, false)
-		skipped = false
 	}
+	return nil
+}
 
-	sizeOrigMB := bytesToMB(sizeOrig)
-	sizeNewMB := bytesToMB(sizeNew)
-	sizeSavedMB := bytesToMB(sizeOrig - sizeNew)
+// resumeAsset continues an asset, and the motion video of a Live Photo,
+// from the stage recorded in the journal. It reports whether the asset needs
+// no further processing. A motion video is only resumed with its still: when
+// the still did not reach the server, the copy of the motion video is
+// trashed and both start over.
+func resumeAsset(client *immich.ClientSimple, journal *journal, asset immich.AssetResponseDto) (bool, error) {
+	if asset.Type == immich.VIDEO && asset.Visibility == immich.Hidden {
+		return false, nil
+	}
+	entry, ok := journal.entry(asset.Id)
+	if !ok || entry.Stage == StageDownloaded || entry.Stage == StageEncoded {
+		if err := discardMotion(client, journal, asset); err != nil {
+			return false, err
+		}
+	}
+	if !ok {
+		return false, nil
+	}
 
-	if skipped {
-		fmt.Printf("✗ Skipped: %s (Original: %.2f MB, Converted: %.2f MB, No size reduction)\n", asset.OriginalFileName, sizeOrigMB, sizeNewMB)
+	switch entry.Stage {
+	case StageSkipped, StageDeleted:
+		return true, nil
+	case StageUploaded, StageTagged:
+		uuidNew, err := immich.UUUIDOfString(entry.NewID)
+		if err != nil {
+			return false, err
+		}
+		replacements := []replacement{{assetID: asset.Id, newID: uuidNew, from: entry.Stage}}
+		if asset.LivePhotoVideoId != nil {
+			motion, ok := journal.entry(*asset.LivePhotoVideoId)
+			if ok && (motion.Stage == StageUploaded || motion.Stage == StageTagged) {
+				uuidMotion, err := immich.UUUIDOfString(motion.NewID)
+				if err != nil {
+					return false, err
+				}
+				replacements = append(replacements, replacement{assetID: motion.AssetID, newID: uuidMotion, from: motion.Stage})
+			}
+		}
+		fmt.Printf("↻ Resuming: %s (%s)\n", asset.OriginalFileName, entry.Stage)
+		return true, finishReplace(client, journal, replacements)
+	default:
+		// Nothing reached the server yet, start over
+		return false, nil
+	}
+}
+
+// discardMotion trashes the uploaded copy of the motion video of a Live
+// Photo whose still was not uploaded, the original motion video stays
+func discardMotion(client *immich.ClientSimple, journal *journal, still immich.AssetResponseDto) error {
+	if still.LivePhotoVideoId == nil {
 		return nil
 	}
-	fmt.Printf("✓ Replaced: %s (Original: %.2f MB, Converted: %.2f MB, Saved: %.2f MB)\n", asset.OriginalFileName, sizeOrigMB, sizeNewMB, sizeSavedMB)
+	motion, ok := journal.entry(*still.LivePhotoVideoId)
+	if !ok || (motion.Stage != StageUploaded && motion.Stage != StageTagged) {
+		return nil
+	}
+	uuidMotion, err := immich.UUUIDOfString(motion.NewID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("↻ Restarting: %s (motion video %s without its still)\n", still.OriginalFileName, motion.Stage)
+	err = client.AssetDeleteMultiple([]types.UUID{uuidMotion}, false)
+	if err != nil {
+		return fmt.Errorf("can not delete the motion video copy: %w", err)
+	}
+	return journal.record(motion.AssetID, StageDownloaded, "")
+}
 
-	return nil
+// shouldReplace reports whether the compressed file is at least diffPercent
+// smaller than the original.
+func shouldReplace(sizeOrig, sizeNew int64, diffPercent int) bool {
+	return sizeOrig-sizeNew > int64(float64(sizeOrig)*(float64(diffPercent)/100))
+}
+
+// ParseSize reads a size in bytes with an optional K, M or G suffix (base 1024)
+func ParseSize(input string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(input))
+	if size == "" {
+		return 0, nil
+	}
+	size = strings.TrimSuffix(size, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(size, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(size, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(size, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", input)
+	}
+	return int64(value * float64(multiplier)), nil
 }
 
 func bytesToMB(bytes int64) float64 {
 	return float64(bytes) / float64(1024*1024)
 }
 
+// downloadFile stores the original of the asset in a temporary file and
+// returns its path. The file is only returned when its SHA1 matches the
+// checksum of the asset.
+func downloadFile(client *immich.ClientSimple, asset immich.AssetResponseDto) (string, error) {
+	uuid, err := uuid.Parse(asset.Id)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse uuid '%s': %w", asset.Id, err)
+	}
+
+	// Create temporary input file
+	fileIn, err := os.Create(filepath.Join(os.TempDir(), fmt.Sprintf("%s%s", uuid.String(), filepath.Ext(asset.OriginalPath))))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp input file: %w", err)
+	}
+	defer fileIn.Close()
+
+	resp, err := client.AssetDownload(uuid)
+	if err != nil {
+		os.Remove(fileIn.Name())
+		return "", fmt.Errorf("failed to fetch asset: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		os.Remove(fileIn.Name())
+		return "", fmt.Errorf("failed to fetch asset: bad status code: %s", resp.Status)
+	}
+
+	// Hash while saving, a truncated download must never replace the original
+	checksum, err := immich.ChecksumOf(io.TeeReader(resp.Body, fileIn))
+	if err != nil {
+		os.Remove(fileIn.Name())
+		return "", fmt.Errorf("failed to save asset to temp file: %w", err)
+	}
+	if err := verifyChecksum(asset, checksum); err != nil {
+		os.Remove(fileIn.Name())
+		return "", err
+	}
+
+	return fileIn.Name(), nil
+}
+
+// verifyChecksum compares the checksum of the download with the one the
+// server stored for the original
+func verifyChecksum(asset immich.AssetResponseDto, checksum string) error {
+	if asset.Checksum != checksum {
+		return fmt.Errorf("checksum mismatch for '%s': expected %s, got %s", asset.OriginalFileName, asset.Checksum, checksum)
+	}
+	return nil
+}
+
 func uploadFile(client *immich.ClientSimple, asset immich.AssetResponseDto, file *os.File) (*types.UUID, error) {
 	r, err := client.AssetUploadCopy(asset, file)
 	if err != nil {
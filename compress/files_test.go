@@ -1,9 +1,15 @@
 package compress
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"immich-compress/immich"
@@ -239,23 +245,243 @@ func TestCompressFileSizeComparison(t *testing.T) {
 			shouldReplace:  false, // 8MB reduction equals exactly 8% threshold, but we need > threshold
 			description:    "8% reduction (exact threshold) should NOT trigger replacement",
 		},
+		{
+			name:           "compressed is bigger",
+			originalSize:   10 * 1024 * 1024, // 10 MB
+			compressedSize: 12 * 1024 * 1024, // 12 MB
+			diffPercent:    0,
+			shouldReplace:  false,
+			description:    "a bigger file should never trigger replacement",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Simulate the logic from compressFile
 			sizeDiff := tt.originalSize - tt.compressedSize
 			threshold := int64(float64(tt.originalSize) * float64(tt.diffPercent) / 100)
-			shouldReplace := sizeDiff > threshold
+			replace := shouldReplace(tt.originalSize, tt.compressedSize, tt.diffPercent)
 
-			if shouldReplace != tt.shouldReplace {
+			if replace != tt.shouldReplace {
 				t.Errorf("%s: sizeDiff=%d, threshold=%d, shouldReplace=%v, want %v. %s",
-					tt.name, sizeDiff, threshold, shouldReplace, tt.shouldReplace, tt.description)
+					tt.name, sizeDiff, threshold, replace, tt.shouldReplace, tt.description)
 			}
 		})
 	}
 }
 
+func TestSkipError(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", skipAsset("similarity %.2f is below %.2f", 0.8, 0.9))
+
+	var skip *skipError
+	if !errors.As(err, &skip) {
+		t.Fatal("Expected wrapped skip error to be detected")
+	}
+	if skip.reason != "similarity 0.80 is below 0.90" {
+		t.Errorf("Unexpected reason %q", skip.reason)
+	}
+
+	if errors.As(fmt.Errorf("plain error"), &skip) {
+		t.Error("Expected plain error not to be a skip")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	asset := immich.AssetResponseDto{OriginalFileName: "IMG_0001.JPG", Checksum: "Kq5sNclPz7QV2+lfQIuc6R7oRu0="}
+	if err := verifyChecksum(asset, "Kq5sNclPz7QV2+lfQIuc6R7oRu0="); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// A truncated download has a different checksum
+	err := verifyChecksum(asset, "2jmj7l5rSw0yVb/vlWAYkK/YBwk=")
+	if err == nil {
+		t.Fatal("Expected a checksum mismatch")
+	}
+	var skip *skipError
+	if errors.As(err, &skip) {
+		t.Error("Expected a mismatch to fail instead of skipping the asset")
+	}
+}
+
+func TestCompressFileHiddenMotionVideo(t *testing.T) {
+	// Motion videos are skipped before anything is downloaded
+	asset := createTestAsset(uuid.New().String(), "VIDEO", "IMG_0001.MOV")
+	asset.Visibility = immich.Hidden
+
+	saved, err := compressFile(context.Background(), nil, nil, nil, asset, 10, false, ImageConfig{}, VideoConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if saved != 0 {
+		t.Errorf("Expected nothing saved, got %d", saved)
+	}
+}
+
+func TestResumeAssetLivePhotoRestartsBoth(t *testing.T) {
+	server := newFakeServer(t)
+	server.Handle("DELETE /assets", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	journal, err := openJournal(filepath.Join(t.TempDir(), "journal.jsonl"), false, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer journal.close()
+
+	motion := createTestAsset(uuid.New().String(), "VIDEO", "IMG_0001.MOV")
+	motion.Visibility = immich.Hidden
+	still := createTestAsset(uuid.New().String(), "IMAGE", "IMG_0001.HEIC")
+	still.LivePhotoVideoId = &motion.Id
+	motionCopy := uuid.NewString()
+	if err := journal.record(motion.Id, StageUploaded, motionCopy); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if err := journal.record(still.Id, StageEncoded, ""); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+
+	// The motion video alone is left for its still
+	done, err := resumeAsset(server.client(t), journal, motion)
+	if err != nil || done {
+		t.Fatalf("Expected the motion video to wait for its still, got %v %v", done, err)
+	}
+	if requests := server.All(); len(requests) > 0 {
+		t.Fatalf("Expected the motion video not to be finished alone, got %+v", requests)
+	}
+
+	done, err = resumeAsset(server.client(t), journal, still)
+	if err != nil || done {
+		t.Fatalf("Expected the Live Photo to start over, got %v %v", done, err)
+	}
+	deletes := server.Received(http.MethodDelete, "/assets")
+	if len(deletes) != 1 || !strings.Contains(string(deletes[0].Body), motionCopy) || strings.Contains(string(deletes[0].Body), motion.Id) {
+		t.Errorf("Expected only the motion video copy to be trashed, got %+v", deletes)
+	}
+	if entry, _ := journal.entry(motion.Id); entry.Stage != StageDownloaded {
+		t.Errorf("Expected the motion video to start over, got %s", entry.Stage)
+	}
+}
+
+// compressedFile writes content to a temporary file as a compressor would
+func compressedFile(t *testing.T, name, content string) *compressed {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return &compressed{file: file}
+}
+
+// multipartFields returns the non-file fields of a multipart request
+func multipartFields(t *testing.T, r *http.Request) map[string]string {
+	t.Helper()
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("Invalid multipart body: %v", err)
+	}
+	fields := map[string]string{}
+	for name, values := range r.MultipartForm.Value {
+		fields[name] = values[0]
+	}
+	return fields
+}
+
+func TestUploadPartsLivePhoto(t *testing.T) {
+	motion := createTestAsset(uuid.NewString(), "VIDEO", "IMG_0001.MOV")
+	still := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.HEIC")
+	still.LivePhotoVideoId = &motion.Id
+	motionNew, stillNew := uuid.NewString(), uuid.NewString()
+
+	server := newFakeServer(t)
+	server.JSON("POST /assets/bulk-upload-check", http.StatusOK, immich.AssetBulkUploadCheckResponseDto{
+		Results: []immich.AssetBulkUploadCheckResult{{Action: immich.Accept}},
+	})
+	var mu sync.Mutex
+	var uploads []map[string]string
+	server.Handle("POST /assets", func(w http.ResponseWriter, r *http.Request) {
+		fields := multipartFields(t, r)
+		mu.Lock()
+		uploads = append(uploads, fields)
+		mu.Unlock()
+		id := stillNew
+		if strings.HasSuffix(fields["filename"], ".mkv") {
+			id = motionNew
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(immich.AssetMediaResponseDto{Id: id, Status: immich.AssetMediaStatusCreated})
+	})
+	server.Handle("PUT /assets/copy", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server.JSON("PUT /assets/"+motionNew+"/metadata", http.StatusOK, []immich.AssetMetadataResponseDto{})
+	server.JSON("PUT /assets/"+stillNew+"/metadata", http.StatusOK, []immich.AssetMetadataResponseDto{})
+
+	results := []*compressed{
+		compressedFile(t, "motion.mkv", "compressed motion video"),
+		compressedFile(t, "still.jxl", "compressed still"),
+	}
+	replacements, err := uploadParts(context.Background(), server.client(t), nil, nil, []immich.AssetResponseDto{motion, still}, results)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(uploads) != 2 {
+		t.Fatalf("Expected two uploads, got %d", len(uploads))
+	}
+	if uploads[0]["filename"] != "IMG_0001.mkv" {
+		t.Errorf("Expected the motion video to be uploaded first, got %v", uploads[0])
+	}
+	if _, ok := uploads[0]["livePhotoVideoId"]; ok {
+		t.Errorf("Expected no livePhotoVideoId for the motion video, got %v", uploads[0])
+	}
+	if uploads[1]["filename"] != "IMG_0001.jxl" || uploads[1]["livePhotoVideoId"] != motionNew {
+		t.Errorf("Expected the still linked to the new motion video %s, got %v", motionNew, uploads[1])
+	}
+	if len(replacements) != 2 || replacements[0].assetID != motion.Id || replacements[1].newID.String() != stillNew {
+		t.Errorf("Expected the replacements of both parts, got %+v", replacements)
+	}
+}
+
+func TestLinkMotion(t *testing.T) {
+	motionID := uuid.New().String()
+	still := createTestAsset(uuid.New().String(), "IMAGE", "IMG_0001.HEIC")
+	still.LivePhotoVideoId = &motionID
+	newMotion := uuid.New()
+
+	linked := linkMotion(still, []replacement{{assetID: motionID, newID: newMotion, from: StageUploaded}})
+	if linked.LivePhotoVideoId == nil || *linked.LivePhotoVideoId != newMotion.String() {
+		t.Errorf("Expected the still to link the compressed motion video %s, got %v", newMotion, linked.LivePhotoVideoId)
+	}
+	if *still.LivePhotoVideoId != motionID {
+		t.Error("Expected the original asset to stay unchanged")
+	}
+
+	// The motion video was skipped and kept
+	kept := linkMotion(still, nil)
+	if kept.LivePhotoVideoId == nil || *kept.LivePhotoVideoId != motionID {
+		t.Errorf("Expected the still to keep its motion video %s, got %v", motionID, kept.LivePhotoVideoId)
+	}
+
+	plain := createTestAsset(uuid.New().String(), "IMAGE", "photo.jpg")
+	if linkMotion(plain, []replacement{{assetID: motionID, newID: newMotion}}).LivePhotoVideoId != nil {
+		t.Error("Expected a plain photo to stay unlinked")
+	}
+}
+
+func TestLivePhotoPartsPlainAsset(t *testing.T) {
+	asset := createTestAsset(uuid.New().String(), "IMAGE", "photo.jpg")
+
+	parts, err := livePhotoParts(nil, asset)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(parts) != 1 || parts[0].Id != asset.Id {
+		t.Errorf("Expected only the asset itself, got %v", parts)
+	}
+}
+
 func TestCompressFileUnsupportedType(t *testing.T) {
 	// Test compressFile with an unsupported asset type
 	asset := immich.AssetResponseDto{
@@ -316,3 +542,31 @@ func hasVideoExtension(filename string) bool {
 	}
 	return false
 }
+
+// TestParseSize verifies sizes with and without unit suffix
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{input: "", expected: 0},
+		{input: "1500", expected: 1500},
+		{input: "500K", expected: 500 * 1024},
+		{input: "20m", expected: 20 * 1024 * 1024},
+		{input: "1.5GB", expected: 1536 * 1024 * 1024},
+		{input: "big", wantErr: true},
+		{input: "-1M", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseSize(%q) = %d, expected %d", tt.input, got, tt.expected)
+		}
+	}
+}
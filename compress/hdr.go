@@ -0,0 +1,168 @@
+package compress
+
+import (
+	"fmt"
+	"math"
+)
+
+// HDRMode selects what happens to HDR videos when the output format can not
+// carry HDR
+type HDRMode string
+
+const (
+	HDRSkip    HDRMode = "skip"
+	HDRTonemap HDRMode = "tonemap"
+)
+
+var HDRModesAvailable = []HDRMode{HDRSkip, HDRTonemap}
+
+// tonemapFilter converts PQ and HLG to 8 bit BT.709 SDR. The hable curve
+// keeps the highlights, desat=0 keeps bright colors from turning grey.
+const tonemapFilter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709," +
+	"tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+
+// colorInfo holds the ffprobe color properties of a stream, empty when the
+// stream does not tell
+type colorInfo struct {
+	primaries  string
+	transfer   string
+	space      string
+	colorRange string
+}
+
+// masteringDisplay is the SMPTE ST 2086 mastering display metadata.
+// Chromaticities are CIE 1931 xy, luminances are in cd/m².
+type masteringDisplay struct {
+	red, green, blue, whitePoint [2]float64
+	minLuminance, maxLuminance   float64
+}
+
+// contentLight is the CTA-861.3 content light level metadata in cd/m²
+type contentLight struct {
+	maxContent, maxAverage int
+}
+
+// parseHDRSideData reads the HDR10 static metadata from the side data of a
+// stream, nil for what is missing
+func parseHDRSideData(sideData []ffprobeSideData) (*masteringDisplay, *contentLight) {
+	var mastering *masteringDisplay
+	var light *contentLight
+	for _, data := range sideData {
+		switch data.SideDataType {
+		case "Mastering display metadata":
+			// Some encoders write the entry with only the luminance set
+			if data.RedX == "" {
+				continue
+			}
+			mastering = &masteringDisplay{
+				red:          [2]float64{parseRational(data.RedX), parseRational(data.RedY)},
+				green:        [2]float64{parseRational(data.GreenX), parseRational(data.GreenY)},
+				blue:         [2]float64{parseRational(data.BlueX), parseRational(data.BlueY)},
+				whitePoint:   [2]float64{parseRational(data.WhitePointX), parseRational(data.WhitePointY)},
+				minLuminance: parseRational(data.MinLuminance),
+				maxLuminance: parseRational(data.MaxLuminance),
+			}
+		case "Content light level metadata":
+			light = &contentLight{maxContent: data.MaxContent, maxAverage: data.MaxAverage}
+		}
+	}
+	return mastering, light
+}
+
+// carriesHDR reports whether the output format can store HDR, h264 is
+// encoded with 8 bit
+func (c *VideoConfig) carriesHDR() bool {
+	return c.Format == AV1 || c.Format == HEVC
+}
+
+// tonemaps reports whether the source is converted to SDR
+func (c *VideoConfig) tonemaps(source mediaStreams) bool {
+	return source.video.hdr && !c.carriesHDR() && c.HDR == HDRTonemap
+}
+
+// checkHDR skips HDR videos the output format can not carry, unless they are
+// tone-mapped
+func (c *VideoConfig) checkHDR(source mediaStreams) error {
+	if source.video.hdr && !c.carriesHDR() && c.HDR != HDRTonemap {
+		return skipAsset("HDR can not be kept in %s", c.Format)
+	}
+	return nil
+}
+
+// colorArgs returns the ffmpeg arguments that tag the output with the color
+// properties of the source. Without them the encoders write untagged video,
+// which players show as BT.709 and HDR looks washed out.
+func (c *VideoConfig) colorArgs(source mediaStreams) []string {
+	if c.tonemaps(source) {
+		return []string{
+			"-color_primaries", "bt709",
+			"-color_trc", "bt709",
+			"-colorspace", "bt709",
+			"-color_range", "tv",
+		}
+	}
+
+	var args []string
+	color := source.video.color
+	for _, property := range []struct{ flag, value string }{
+		{"-color_primaries", color.primaries},
+		{"-color_trc", color.transfer},
+		{"-colorspace", color.space},
+		{"-color_range", color.colorRange},
+	} {
+		if property.value != "" && property.value != "unknown" {
+			args = append(args, property.flag, property.value)
+		}
+	}
+	return args
+}
+
+// hdrParams returns the encoder parameters that pass the HDR10 metadata of
+// the source on, in the syntax of -x265-params or -svtav1-params
+func (c *VideoConfig) hdrParams(source mediaStreams) []string {
+	mastering, light := source.video.mastering, source.video.contentLight
+	if !source.video.hdr || (mastering == nil && light == nil) {
+		return nil
+	}
+	var params []string
+	switch c.Format {
+	case HEVC:
+		params = append(params, "hdr10=1", "repeat-headers=1")
+		if mastering != nil {
+			params = append(params, "master-display="+mastering.x265())
+		}
+		if light != nil {
+			params = append(params, fmt.Sprintf("max-cll=%d,%d", light.maxContent, light.maxAverage))
+		}
+	case AV1:
+		if mastering != nil {
+			params = append(params, "mastering-display="+mastering.svtav1())
+		}
+		if light != nil {
+			params = append(params, fmt.Sprintf("content-light=%d,%d", light.maxContent, light.maxAverage))
+		}
+	}
+	return params
+}
+
+// x265 returns the master-display value of x265, chromaticities in steps of
+// 0.00002 and luminances in steps of 0.0001 cd/m²
+func (m *masteringDisplay) x265() string {
+	xy := func(point [2]float64) string {
+		return fmt.Sprintf("(%d,%d)", int(math.Round(point[0]*50000)), int(math.Round(point[1]*50000)))
+	}
+	return fmt.Sprintf("G%sB%sR%sWP%sL(%d,%d)",
+		xy(m.green), xy(m.blue), xy(m.red), xy(m.whitePoint),
+		int(math.Round(m.maxLuminance*10000)), int(math.Round(m.minLuminance*10000)))
+}
+
+// svtav1 returns the mastering-display value of SVT-AV1, which takes plain
+// numbers
+func (m *masteringDisplay) svtav1() string {
+	xy := func(point [2]float64) string {
+		return fmt.Sprintf("(%.4f,%.4f)", point[0], point[1])
+	}
+	return fmt.Sprintf("G%sB%sR%sWP%sL(%.4f,%.4f)",
+		xy(m.green), xy(m.blue), xy(m.red), xy(m.whitePoint),
+		m.maxLuminance, m.minLuminance)
+}
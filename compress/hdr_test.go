@@ -0,0 +1,178 @@
+package compress
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+const ffprobeStreamsHDR10 = `{
+	"streams": [
+		{
+			"codec_type": "video", "codec_name": "hevc", "width": 3840, "height": 2160, "pix_fmt": "yuv420p10le",
+			"color_range": "tv", "color_space": "bt2020nc", "color_transfer": "smpte2084", "color_primaries": "bt2020",
+			"side_data_list": [
+				{
+					"side_data_type": "Mastering display metadata",
+					"red_x": "34000/50000", "red_y": "16000/50000",
+					"green_x": "13250/50000", "green_y": "34500/50000",
+					"blue_x": "7500/50000", "blue_y": "3000/50000",
+					"white_point_x": "15635/50000", "white_point_y": "16450/50000",
+					"min_luminance": "50/10000", "max_luminance": "10000000/10000"
+				},
+				{"side_data_type": "Content light level metadata", "max_content": 1000, "max_average": 400}
+			]
+		}
+	]
+}`
+
+func hdr10Streams(t *testing.T) mediaStreams {
+	t.Helper()
+	streams, err := parseStreams([]byte(ffprobeStreamsHDR10))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return streams
+}
+
+func TestParseStreamsHDR10(t *testing.T) {
+	video := hdr10Streams(t).video
+	if !video.hdr {
+		t.Error("Expected HDR")
+	}
+	expectedColor := colorInfo{primaries: "bt2020", transfer: "smpte2084", space: "bt2020nc", colorRange: "tv"}
+	if video.color != expectedColor {
+		t.Errorf("Expected color %+v, got %+v", expectedColor, video.color)
+	}
+	if video.mastering == nil {
+		t.Fatal("Expected mastering display metadata")
+	}
+	if video.mastering.red != [2]float64{0.68, 0.32} || video.mastering.maxLuminance != 1000 || video.mastering.minLuminance != 0.005 {
+		t.Errorf("Unexpected mastering display %+v", *video.mastering)
+	}
+	if video.contentLight == nil || *video.contentLight != (contentLight{maxContent: 1000, maxAverage: 400}) {
+		t.Errorf("Unexpected content light level %+v", video.contentLight)
+	}
+}
+
+func TestParseHDRSideDataEmpty(t *testing.T) {
+	mastering, light := parseHDRSideData([]ffprobeSideData{
+		{SideDataType: "Display Matrix", Rotation: -90},
+		{SideDataType: "Mastering display metadata", MaxLuminance: "10000000/10000"},
+	})
+	if mastering != nil || light != nil {
+		t.Errorf("Expected no HDR metadata, got %+v %+v", mastering, light)
+	}
+}
+
+func TestMasteringDisplay(t *testing.T) {
+	mastering := hdr10Streams(t).video.mastering
+	expected := "G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,50)"
+	if got := mastering.x265(); got != expected {
+		t.Errorf("x265() = %s, expected %s", got, expected)
+	}
+	expected = "G(0.2650,0.6900)B(0.1500,0.0600)R(0.6800,0.3200)WP(0.3127,0.3290)L(1000.0000,0.0050)"
+	if got := mastering.svtav1(); got != expected {
+		t.Errorf("svtav1() = %s, expected %s", got, expected)
+	}
+}
+
+func TestVideoConfigColorArgs(t *testing.T) {
+	hdr10 := hdr10Streams(t)
+	colorTags := []string{"-color_primaries", "bt2020", "-color_trc", "smpte2084", "-colorspace", "bt2020nc", "-color_range", "tv"}
+	bt709 := []string{"-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709", "-color_range", "tv"}
+	sdr := mediaStreams{video: videoStream{color: colorInfo{primaries: "bt709", transfer: "unknown"}}}
+
+	tests := []struct {
+		name     string
+		config   VideoConfig
+		source   mediaStreams
+		expected []string
+	}{
+		{"hevc hdr10", VideoConfig{Format: HEVC}, hdr10, colorTags},
+		{"h264 tonemap", VideoConfig{Format: H264, HDR: HDRTonemap}, hdr10, bt709},
+		{"sdr skips unknown", VideoConfig{Format: HEVC}, sdr, []string{"-color_primaries", "bt709"}},
+		{"untagged", VideoConfig{Format: AV1}, mediaStreams{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.colorArgs(tt.source); !slices.Equal(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVideoConfigEncoderArgs(t *testing.T) {
+	hdr10 := hdr10Streams(t)
+	tests := []struct {
+		name     string
+		config   VideoConfig
+		source   mediaStreams
+		expected []string
+	}{
+		{"hevc hdr10", VideoConfig{Format: HEVC}, hdr10, []string{
+			"-x265-params", "hdr10=1:repeat-headers=1:master-display=G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,50):max-cll=1000,400"}},
+		{"av1 hdr10", VideoConfig{Format: AV1}, hdr10, []string{
+			"-svtav1-params", "mastering-display=G(0.2650,0.6900)B(0.1500,0.0600)R(0.6800,0.3200)WP(0.3127,0.3290)L(1000.0000,0.0050):content-light=1000,400"}},
+		{"hevc threads with hdr10", VideoConfig{Format: HEVC, Threads: 4}, hdr10, []string{
+			"-threads", "4",
+			"-x265-params", "pools=4:hdr10=1:repeat-headers=1:master-display=G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,50):max-cll=1000,400"}},
+		{"av1 threads", VideoConfig{Format: AV1, Threads: 4}, mediaStreams{}, []string{"-threads", "4"}},
+		{"h264 tonemapped", VideoConfig{Format: H264, HDR: HDRTonemap}, hdr10, nil},
+		{"sdr", VideoConfig{Format: HEVC}, mediaStreams{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.encoderArgs(tt.source); !slices.Equal(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestVideoConfigCheckHDR(t *testing.T) {
+	hdr := mediaStreams{video: videoStream{hdr: true}}
+	tests := []struct {
+		name     string
+		config   VideoConfig
+		source   mediaStreams
+		expected bool
+	}{
+		{"hevc keeps hdr", VideoConfig{Format: HEVC}, hdr, false},
+		{"av1 keeps hdr", VideoConfig{Format: AV1}, hdr, false},
+		{"h264 skips hdr", VideoConfig{Format: H264, HDR: HDRSkip}, hdr, true},
+		{"h264 tonemaps hdr", VideoConfig{Format: H264, HDR: HDRTonemap}, hdr, false},
+		{"h264 sdr", VideoConfig{Format: H264}, mediaStreams{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.checkHDR(tt.source)
+			var skip *skipError
+			if skipped := errors.As(err, &skip); skipped != tt.expected {
+				t.Errorf("Expected skip %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestVideoConfigTonemapFilters(t *testing.T) {
+	config := VideoConfig{Format: H264, HDR: HDRTonemap, MaxFPS: 30}
+	source := mediaStreams{video: videoStream{hdr: true, fps: 60}}
+
+	filters := config.videoFilters(source)
+	if len(filters) != 2 || filters[0] != "fps=30" || filters[1] != tonemapFilter {
+		t.Errorf("Expected the fps filter and tone-mapping, got %v", filters)
+	}
+	if got := config.referenceFilter(source); got != "fps=30,"+tonemapFilter {
+		t.Errorf("Expected the reference to be tone-mapped too, got %s", got)
+	}
+
+	config.Format = HEVC
+	if got := config.videoFilters(source); slices.Contains(got, tonemapFilter) {
+		t.Errorf("Expected no tone-mapping for hevc, got %v", got)
+	}
+}
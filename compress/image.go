@@ -3,9 +3,9 @@ package compress
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"immich-compress/immich"
 
@@ -16,8 +16,70 @@ import (
 type ImageConfig struct {
 	Format  ImageFormat
 	Quality int
+	// MinSimilarity rejects the compressed image when its SSIM against the
+	// original is lower (0-1, 0 disables the check)
+	MinSimilarity float64
+	// TargetSimilarity searches the lowest quality per image that still
+	// reaches this SSIM instead of using Quality (0-1, 0 disables the search).
+	// The search never settles below MinSimilarity.
+	TargetSimilarity float64
+	// Lossless encodes without loss, Quality and the similarity settings
+	// are ignored then
+	Lossless bool
+	// MaxDimension downscales images whose longer side is larger, 0 keeps
+	// the resolution
+	MaxDimension int
+	AVIF         AVIFOptions
 }
 
+// AVIFOptions are the encoder settings only AVIF has
+type AVIFOptions struct {
+	// Quality replaces ImageConfig.Quality for AVIF, 0 keeps it
+	Quality int
+	// Effort is the CPU effort of the AV1 encoder, 0 (fastest) to 9
+	Effort    int
+	Subsample AVIFSubsample
+	// BitDepth is 8, 10 or 12, 0 keeps 8
+	BitDepth int
+}
+
+// AVIFEffortMax is the slowest and smallest AVIF effort
+const AVIFEffortMax = 9
+
+// AVIFSubsample selects the chroma subsampling of AVIF
+type AVIFSubsample string
+
+const (
+	// AVIFSubsampleAuto lets libvips pick, 4:4:4 for high qualities
+	AVIFSubsampleAuto AVIFSubsample = "auto"
+	AVIFSubsample420  AVIFSubsample = "420"
+	AVIFSubsample444  AVIFSubsample = "444"
+)
+
+var AVIFSubsamplesAvailable = []AVIFSubsample{AVIFSubsampleAuto, AVIFSubsample420, AVIFSubsample444}
+
+var AVIFBitDepthsAvailable = []int{8, 10, 12}
+
+// mode returns the libvips subsample mode
+func (s AVIFSubsample) mode() vips.Subsample {
+	switch s {
+	case AVIFSubsample420:
+		return vips.SubsampleOn
+	case AVIFSubsample444:
+		return vips.SubsampleOff
+	default:
+		return vips.SubsampleAuto
+	}
+}
+
+const (
+	imageQualityMin = 1
+	imageQualityMax = 100
+)
+
+// SimilarityMax is the SSIM of two identical images
+const SimilarityMax = 1.0
+
 type ImageFormat string
 
 const (
@@ -26,88 +88,270 @@ const (
 	JXL  ImageFormat = "jxl"
 	WEBP ImageFormat = "webp"
 	HEIF ImageFormat = "heif"
+	AVIF ImageFormat = "avif"
+	// JXLLosslessJPEG repacks JPEGs into JPEG XL without decoding them, the
+	// original JPEG can be rebuilt bit-exact
+	JXLLosslessJPEG ImageFormat = "jxl-lossless-jpeg"
 )
 
-var ImageFormatsAvailable = []ImageFormat{JPG, JPEG, JXL, WEBP, HEIF}
+var ImageFormatsAvailable = []ImageFormat{JPG, JPEG, JXL, WEBP, HEIF, AVIF, JXLLosslessJPEG}
+
+// extension returns the file extension of the format
+func (f ImageFormat) extension() string {
+	if f == JXLLosslessJPEG {
+		return string(JXL)
+	}
+	return string(f)
+}
 
-func (c *ImageConfig) compress(ctx context.Context, client *immich.ClientSimple, asset immich.AssetResponseDto) (*os.File, error) {
+func (c *ImageConfig) compress(ctx context.Context, asset immich.AssetResponseDto, fileIn string) (*compressed, error) {
 	uuid, err := uuid.Parse(asset.Id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse uuid '%s': %w", asset.Id, err)
 	}
-	// Fetch an image from the client
-	resp, err := client.AssetDownload(uuid)
+	fileOutPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s-compressed.%s", uuid.String(), c.Format.extension()))
+
+	if c.Format == JXLLosslessJPEG {
+		// The bit-exact reconstruction proves pixels and metadata survived
+		return transcodeJPEG(ctx, fileIn, fileOutPath)
+	}
+
+	// Load image from the downloaded original
+	image, err := vips.NewImageFromFile(fileIn, vips.DefaultLoadOptions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	defer image.Close() // always close images to free memory
+
+	// The quality checks below compare with the downscaled image, they
+	// judge the compression and not the resolution cap
+	err = c.downscale(image)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch image: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Read the response body into a byte buffer
-	fileBytes, err := io.ReadAll(resp.Body)
+	// The encoder writes straight into the output file, so no encoded
+	// image is held in memory
+	quality, err := c.encode(image, asset, fileOutPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		os.Remove(fileOutPath)
+		return nil, err
 	}
 
-	// Load image from buffer instead of source
-	image, err := vips.NewImageFromBuffer(fileBytes, vips.DefaultLoadOptions())
+	fileOut, err := os.Open(fileOutPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load image: %w", err)
+		return nil, fmt.Errorf("failed to open temp output file: %w", err)
 	}
-	defer image.Close() // always close images to free memory
 
-	var imageBytes []byte
+	return &compressed{file: fileOut, quality: quality}, nil
+}
+
+// encode writes the image to fileOut in the configured format and checks
+// the result. It returns the picked quality when it was searched.
+func (c *ImageConfig) encode(image *vips.Image, asset immich.AssetResponseDto, fileOut string) (string, error) {
+	var quality string
+	if c.TargetSimilarity > 0 && !c.Lossless {
+		q, err := c.searchQuality(image, asset, fileOut)
+		if err != nil {
+			return "", err
+		}
+		quality = fmt.Sprintf("%s-q%d", c.Format, q)
+	} else {
+		err := c.export(image, c.quality(), fileOut)
+		if err != nil {
+			return "", err
+		}
+		if c.MinSimilarity > 0 && !c.Lossless {
+			err = c.checkSimilarity(image, fileOut, asset)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if asset.ExifInfo != nil {
+		err := checkMetadata(image, fileOut, *asset.ExifInfo)
+		if err != nil {
+			return "", err
+		}
+	}
+	return quality, nil
+}
+
+// downscale shrinks the image in place so that its longer side is at most
+// MaxDimension. Smaller images keep their resolution.
+func (c *ImageConfig) downscale(image *vips.Image) error {
+	if c.MaxDimension <= 0 {
+		return nil
+	}
+	longest := max(image.Width(), image.Height())
+	if longest <= c.MaxDimension {
+		return nil
+	}
+	options := vips.DefaultResizeOptions()
+	options.Kernel = vips.KernelLanczos3
+	if err := image.Resize(float64(c.MaxDimension)/float64(longest), options); err != nil {
+		return fmt.Errorf("failed to downscale image: %w", err)
+	}
+	return nil
+}
+
+// quality returns the configured quality of the format
+func (c *ImageConfig) quality() int {
+	if c.Format == AVIF && c.AVIF.Quality > 0 {
+		return c.AVIF.Quality
+	}
+	return c.Quality
+}
+
+// export encodes the image in the configured format at the given quality
+// into file
+func (c *ImageConfig) export(image *vips.Image, quality int, file string) error {
 	var exportErr error
 
 	// 3. Use a switch to call the correct exporter
 	switch c.Format {
 	case JPEG, JPG:
-		options := vips.DefaultJpegsaveBufferOptions()
-		options.Q = c.Quality
+		if c.Lossless {
+			return fmt.Errorf("%s has no lossless mode", c.Format)
+		}
+		options := vips.DefaultJpegsaveOptions()
+		options.Q = quality
 		options.Keep = vips.KeepAll
-		imageBytes, exportErr = image.JpegsaveBuffer(options)
+		exportErr = image.Jpegsave(file, options)
 
 	case JXL:
-		options := vips.DefaultJxlsaveBufferOptions()
-		options.Q = c.Quality
+		// libvips turns Q into the butteraugli distance of the encoder
+		options := vips.DefaultJxlsaveOptions()
+		options.Q = quality
 		options.Keep = vips.KeepAll
 		options.Effort = 9
-		imageBytes, exportErr = image.JxlsaveBuffer(options)
+		options.Lossless = c.Lossless
+		exportErr = image.Jxlsave(file, options)
 
 	case WEBP:
-		options := vips.DefaultWebpsaveBufferOptions()
-		options.Q = c.Quality
+		options := vips.DefaultWebpsaveOptions()
+		options.Q = quality
 		options.Keep = vips.KeepAll
 		options.Effort = 9
-		imageBytes, exportErr = image.WebpsaveBuffer(options)
+		options.Lossless = c.Lossless
+		exportErr = image.Webpsave(file, options)
 
 	case HEIF:
-		options := vips.DefaultHeifsaveBufferOptions()
-		options.Q = c.Quality
+		options := vips.DefaultHeifsaveOptions()
+		options.Q = quality
 		options.Keep = vips.KeepAll
 		options.Effort = 9
-		imageBytes, exportErr = image.HeifsaveBuffer(options)
+		options.Lossless = c.Lossless
+		exportErr = image.Heifsave(file, options)
+
+	case AVIF:
+		// AVIF is HEIF with AV1 instead of HEVC
+		options := vips.DefaultHeifsaveOptions()
+		options.Compression = vips.HeifCompressionAv1
+		options.Q = quality
+		options.Keep = vips.KeepAll
+		options.Effort = c.AVIF.Effort
+		options.SubsampleMode = c.AVIF.Subsample.mode()
+		if c.AVIF.BitDepth > 0 {
+			options.Bitdepth = c.AVIF.BitDepth
+		}
+		options.Lossless = c.Lossless
+		exportErr = image.Heifsave(file, options)
 
 	default:
-		return nil, fmt.Errorf("unsupported output format: %s", c.Format)
+		return fmt.Errorf("unsupported output format: %s", c.Format)
 	}
 
 	// Check for errors during the export
 	if exportErr != nil {
-		return nil, fmt.Errorf("failed to export image to %s: %w", c.Format, exportErr)
+		return fmt.Errorf("failed to export image to %s: %w", c.Format, exportErr)
+	}
+
+	return nil
+}
+
+// searchQuality binary searches the lowest quality whose output still
+// reaches the searchTarget. A lower quality gives a smaller file, so this is
+// the smallest acceptable output. It is left in fileOut, the candidates are
+// encoded next to it.
+func (c *ImageConfig) searchQuality(original *vips.Image, asset immich.AssetResponseDto, fileOut string) (int, error) {
+	target := c.searchTarget()
+	bestQuality := 0
+	bestScore := 0.0
+	low, high := imageQualityMin, imageQualityMax
+	for low <= high {
+		quality := (low + high) / 2
+		// A name per quality, libvips caches loaded files by name
+		candidate := fmt.Sprintf("%s.q%d", fileOut, quality)
+		score, err := c.scoreCandidate(original, quality, candidate)
+		if err != nil {
+			os.Remove(candidate)
+			return 0, err
+		}
+		if score >= target {
+			if err := os.Rename(candidate, fileOut); err != nil {
+				os.Remove(candidate)
+				return 0, fmt.Errorf("failed to keep candidate: %w", err)
+			}
+			bestQuality, bestScore = quality, score
+			high = quality - 1
+		} else {
+			os.Remove(candidate)
+			low = quality + 1
+		}
+	}
+
+	if bestQuality == 0 {
+		return 0, skipAsset("similarity %.4f is not reachable", target)
+	}
+	fmt.Printf("Quality: %d (similarity %.4f) %s\n", bestQuality, bestScore, asset.OriginalFileName)
+
+	return bestQuality, nil
+}
+
+// searchTarget returns the similarity the quality search has to reach,
+// MinSimilarity when it is stricter than TargetSimilarity
+func (c *ImageConfig) searchTarget() float64 {
+	return max(c.TargetSimilarity, c.MinSimilarity)
+}
+
+// scoreCandidate encodes the image at quality into file and returns its
+// similarity to the original
+func (c *ImageConfig) scoreCandidate(original *vips.Image, quality int, file string) (float64, error) {
+	if err := c.export(original, quality, file); err != nil {
+		return 0, err
 	}
+	return similarityOfFile(original, file)
+}
 
-	// Create temporary output file
-	fileOut, err := os.Create(filepath.Join(os.TempDir(), fmt.Sprintf("%s-compressed.%s", uuid.String(), c.Format)))
+// checkMetadata reads the EXIF of the compressed image back and rejects it
+// when it does not match what Immich knows about the original
+func checkMetadata(original *vips.Image, file string, exif immich.ExifResponseDto) error {
+	compressed, err := vips.NewImageFromFile(file, vips.DefaultLoadOptions())
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp output file: %w", err)
+		return fmt.Errorf("failed to load compressed image: %w", err)
 	}
-	defer fileOut.Close()
+	defer compressed.Close()
 
-	_, err = fileOut.Write(imageBytes)
+	mismatched := verifyImageMetadata(readImageMetadata(original), readImageMetadata(compressed), exif)
+	if len(mismatched) > 0 {
+		return skipAsset("metadata mismatch: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
+}
+
+// checkSimilarity compares the compressed image with the original and
+// rejects it when it falls below MinSimilarity
+func (c *ImageConfig) checkSimilarity(original *vips.Image, file string, asset immich.AssetResponseDto) error {
+	score, err := similarityOfFile(original, file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save image to temp file: %w", err)
+		return err
 	}
-	fileOut.Close() // Close to ensure all data is written
+	fmt.Printf("Similarity: %.4f %s\n", score, asset.OriginalFileName)
 
-	return fileOut, nil
+	if score < c.MinSimilarity {
+		return skipAsset("similarity %.4f is below %.4f", score, c.MinSimilarity)
+	}
+	return nil
 }
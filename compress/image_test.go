@@ -37,7 +37,7 @@ func TestImageConfigInvalidUUID(t *testing.T) {
 
 func TestImageConfigSupportedFormats(t *testing.T) {
 	// Test that all supported formats are recognized
-	supportedFormats := []ImageFormat{JPG, JPEG, JXL, WEBP, HEIF}
+	supportedFormats := []ImageFormat{JPG, JPEG, JXL, WEBP, HEIF, AVIF}
 
 	for _, format := range supportedFormats {
 		config := ImageConfig{
@@ -60,7 +60,7 @@ func TestImageConfigUnsupportedFormat(t *testing.T) {
 
 	// Test the format validation by checking if the switch statement handles unsupported formats
 	switch config.Format {
-	case JPEG, JPG, JXL, WEBP, HEIF:
+	case JPEG, JPG, JXL, WEBP, HEIF, AVIF:
 		t.Errorf("Expected format %s to be unsupported, but it was accepted", config.Format)
 	default:
 		// Unsupported format - this is expected for this test
@@ -164,6 +164,140 @@ func TestImageConfigHEIF(t *testing.T) {
 	}
 }
 
+func TestImageConfigAVIF(t *testing.T) {
+	// Test AVIF configuration
+	config := ImageConfig{
+		Format:  AVIF,
+		Quality: 80,
+		AVIF: AVIFOptions{
+			Quality:   55,
+			Effort:    6,
+			Subsample: AVIFSubsample420,
+			BitDepth:  10,
+		},
+	}
+
+	if config.Format != AVIF {
+		t.Errorf("Expected AVIF format, got %s", config.Format)
+	}
+	if got := config.quality(); got != 55 {
+		t.Errorf("Expected the AVIF quality 55, got %d", got)
+	}
+	if got := config.Format.extension(); got != "avif" {
+		t.Errorf("Expected extension avif, got %s", got)
+	}
+}
+
+func TestImageConfigQuality(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ImageConfig
+		expected int
+	}{
+		{name: "avif quality", config: ImageConfig{Format: AVIF, Quality: 80, AVIF: AVIFOptions{Quality: 50}}, expected: 50},
+		{name: "avif without own quality", config: ImageConfig{Format: AVIF, Quality: 80}, expected: 80},
+		{name: "other format ignores avif quality", config: ImageConfig{Format: WEBP, Quality: 80, AVIF: AVIFOptions{Quality: 50}}, expected: 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.quality(); got != tt.expected {
+				t.Errorf("quality() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAVIFSubsampleMode(t *testing.T) {
+	tests := []struct {
+		subsample AVIFSubsample
+		expected  vips.Subsample
+	}{
+		{AVIFSubsampleAuto, vips.SubsampleAuto},
+		{AVIFSubsample420, vips.SubsampleOn},
+		{AVIFSubsample444, vips.SubsampleOff},
+		{AVIFSubsample(""), vips.SubsampleAuto},
+	}
+
+	for _, tt := range tests {
+		if got := tt.subsample.mode(); got != tt.expected {
+			t.Errorf("%q.mode() = %v, expected %v", tt.subsample, got, tt.expected)
+		}
+	}
+}
+
+func TestImageConfigAVIFExport(t *testing.T) {
+	// Skip test if vips is not available
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skip("vips not available, skipping integration test")
+		}
+	}()
+
+	image, err := vips.NewBlack(64, 64, nil)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	defer image.Close()
+
+	config := ImageConfig{Format: AVIF, Quality: 60, AVIF: AVIFOptions{Effort: 2, Subsample: AVIFSubsample444, BitDepth: 10}}
+	file := filepath.Join(t.TempDir(), "black.avif")
+	err = config.export(image, config.quality(), file)
+	if err != nil {
+		t.Skipf("AVIF encoding not available: %v", err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+		t.Errorf("Expected AVIF output, got %v", err)
+	}
+}
+
+func TestImageConfigDownscaleDisabled(t *testing.T) {
+	// Without a cap the image is not touched at all
+	config := ImageConfig{Format: JXL, Quality: 80}
+	if err := config.downscale(nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestImageConfigDownscale(t *testing.T) {
+	tests := []struct {
+		name           string
+		width, height  int
+		maxDimension   int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{name: "landscape above cap", width: 400, height: 200, maxDimension: 100, expectedWidth: 100, expectedHeight: 50},
+		{name: "portrait above cap", width: 200, height: 400, maxDimension: 100, expectedWidth: 50, expectedHeight: 100},
+		{name: "below cap", width: 80, height: 60, maxDimension: 100, expectedWidth: 80, expectedHeight: 60},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Skip test if vips is not available
+			defer func() {
+				if r := recover(); r != nil {
+					t.Skip("vips not available, skipping integration test")
+				}
+			}()
+
+			image, err := vips.NewBlack(tt.width, tt.height, nil)
+			if err != nil {
+				t.Fatalf("Failed to create image: %v", err)
+			}
+			defer image.Close()
+
+			config := ImageConfig{MaxDimension: tt.maxDimension}
+			if err := config.downscale(image); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if image.Width() != tt.expectedWidth || image.Height() != tt.expectedHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", tt.expectedWidth, tt.expectedHeight, image.Width(), image.Height())
+			}
+		})
+	}
+}
+
 func TestImageConfigEdgeCases(t *testing.T) {
 	// Test edge cases
 	tests := []struct {
@@ -212,7 +346,7 @@ func TestImageConfigEdgeCases(t *testing.T) {
 
 func TestImageFormatsAvailable(t *testing.T) {
 	// Test the available formats are correctly defined
-	expectedFormats := []ImageFormat{JPG, JPEG, JXL, WEBP, HEIF}
+	expectedFormats := []ImageFormat{JPG, JPEG, JXL, WEBP, HEIF, AVIF, JXLLosslessJPEG}
 
 	if len(ImageFormatsAvailable) != len(expectedFormats) {
 		t.Errorf("Expected %d formats, got %d", len(expectedFormats), len(ImageFormatsAvailable))
@@ -333,3 +467,99 @@ func TestImageConfigJPEGVsJPG(t *testing.T) {
 		t.Errorf("JPG format %s should be supported", jpgConfig.Format)
 	}
 }
+
+func TestImageConfigSearchQuality(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skip("vips not available, skipping integration test")
+		}
+	}()
+
+	image, err := vips.NewGaussnoise(64, 48, &vips.GaussnoiseOptions{Sigma: 30, Mean: 128})
+	if err != nil {
+		t.Fatalf("Failed to create noise image: %v", err)
+	}
+	defer image.Close()
+
+	config := ImageConfig{Format: JPEG, TargetSimilarity: 0.9}
+	asset := createTestAsset(uuid.New().String(), "IMAGE", "noise.jpg")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "noise.jpg")
+	quality, err := config.searchQuality(image, asset, file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if quality < imageQualityMin || quality > imageQualityMax {
+		t.Errorf("Expected quality in range, got %d", quality)
+	}
+
+	score, err := similarityOfFile(image, file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score < config.TargetSimilarity {
+		t.Errorf("Expected similarity of at least %v, got %v", config.TargetSimilarity, score)
+	}
+
+	// One step lower must miss the target, otherwise the search stopped early
+	if quality > imageQualityMin {
+		score, err := config.scoreCandidate(image, quality-1, filepath.Join(dir, "lower.jpg"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if score >= config.TargetSimilarity {
+			t.Errorf("Expected quality %d to miss the target, got %v", quality-1, score)
+		}
+	}
+
+	// Only the picked output is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) > 2 {
+		t.Errorf("Expected the candidates to be removed, got %d files", len(entries))
+	}
+}
+
+func TestImageConfigSearchQualityUnreachable(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skip("vips not available, skipping integration test")
+		}
+	}()
+
+	image, err := vips.NewGaussnoise(64, 48, &vips.GaussnoiseOptions{Sigma: 30, Mean: 128})
+	if err != nil {
+		t.Fatalf("Failed to create noise image: %v", err)
+	}
+	defer image.Close()
+
+	// JPEG is never lossless, so a perfect score is out of reach
+	config := ImageConfig{Format: JPEG, TargetSimilarity: 1.1}
+	asset := createTestAsset(uuid.New().String(), "IMAGE", "noise.jpg")
+
+	_, err = config.searchQuality(image, asset, filepath.Join(t.TempDir(), "noise.jpg"))
+	var skip *skipError
+	if !errors.As(err, &skip) {
+		t.Errorf("Expected the asset to be skipped, got %v", err)
+	}
+}
+
+func TestImageConfigSearchTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ImageConfig
+		expected float64
+	}{
+		{"target only", ImageConfig{TargetSimilarity: 0.95}, 0.95},
+		{"stricter minimum", ImageConfig{TargetSimilarity: 0.9, MinSimilarity: 0.97}, 0.97},
+		{"looser minimum", ImageConfig{TargetSimilarity: 0.98, MinSimilarity: 0.9}, 0.98},
+	}
+	for _, tt := range tests {
+		if got := tt.config.searchTarget(); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
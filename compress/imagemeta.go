@@ -0,0 +1,179 @@
+package compress
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"immich-compress/immich"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+// exifDateLayout is how EXIF stores DateTimeOriginal
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// gpsTolerance is the allowed coordinate difference in degrees, about 10 m
+const gpsTolerance = 1e-4
+
+// imageMetadata is the EXIF of an image that has to survive the encode
+type imageMetadata struct {
+	dateTimeOriginal string
+	hasGPS           bool
+	latitude         float64
+	longitude        float64
+	orientation      int
+	make             string
+	model            string
+	hasICC           bool
+}
+
+// readImageMetadata collects the EXIF fields libvips decoded from a file
+func readImageMetadata(image *vips.Image) imageMetadata {
+	metadata := imageMetadata{
+		dateTimeOriginal: exifField(image, "exif-ifd2-DateTimeOriginal"),
+		orientation:      image.Orientation(),
+		make:             exifField(image, "exif-ifd0-Make"),
+		model:            exifField(image, "exif-ifd0-Model"),
+		hasICC:           image.HasICCProfile(),
+	}
+	latitude, errLatitude := parseGPS(exifField(image, "exif-ifd3-GPSLatitude"), exifField(image, "exif-ifd3-GPSLatitudeRef"))
+	longitude, errLongitude := parseGPS(exifField(image, "exif-ifd3-GPSLongitude"), exifField(image, "exif-ifd3-GPSLongitudeRef"))
+	if errLatitude == nil && errLongitude == nil {
+		metadata.hasGPS = true
+		metadata.latitude = latitude
+		metadata.longitude = longitude
+	}
+	return metadata
+}
+
+// exifField returns the value of an EXIF field or "" when it is missing
+func exifField(image *vips.Image, name string) string {
+	if !image.HasField(name) {
+		return ""
+	}
+	value, err := image.GetString(name)
+	if err != nil {
+		return ""
+	}
+	return exifValue(value)
+}
+
+// exifValue strips the description libvips appends to EXIF strings, as in
+// "Apple (Apple, ASCII, 6 components, 6 bytes)". The value is repeated in
+// the description, which tells the separator apart from brackets in it.
+func exifValue(value string) string {
+	for i := strings.Index(value, " ("); i >= 0; {
+		if strings.HasPrefix(value[i+2:], value[:i]+", ") {
+			return strings.TrimSpace(value[:i])
+		}
+		next := strings.Index(value[i+2:], " (")
+		if next < 0 {
+			break
+		}
+		i += 2 + next
+	}
+	return strings.TrimSpace(value)
+}
+
+// parseGPS turns "52, 31, 12.34" and a reference of N, S, E or W into
+// signed decimal degrees
+func parseGPS(value, ref string) (float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid gps coordinate '%s'", value)
+	}
+	var degrees float64
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid gps coordinate '%s': %w", value, err)
+		}
+		degrees += number / math.Pow(60, float64(i))
+	}
+	if ref == "S" || ref == "W" {
+		degrees = -degrees
+	}
+	return degrees, nil
+}
+
+// verifyImageMetadata compares the EXIF of the compressed image with what
+// Immich extracted from the original. Only fields the original file carries
+// are checked, Immich fills the others from elsewhere. It returns the names
+// of the fields that do not match.
+func verifyImageMetadata(original, compressed imageMetadata, exif immich.ExifResponseDto) []string {
+	var mismatched []string
+	if original.dateTimeOriginal != "" && exif.DateTimeOriginal != nil {
+		expected := exif.DateTimeOriginal.In(exifLocation(exif.TimeZone)).Format(exifDateLayout)
+		if compressed.dateTimeOriginal != expected {
+			mismatched = append(mismatched, "DateTimeOriginal")
+		}
+	}
+	if original.hasGPS && exif.Latitude != nil && exif.Longitude != nil {
+		if !compressed.hasGPS ||
+			math.Abs(compressed.latitude-float64(*exif.Latitude)) > gpsTolerance ||
+			math.Abs(compressed.longitude-float64(*exif.Longitude)) > gpsTolerance {
+			mismatched = append(mismatched, "GPS")
+		}
+	}
+	if exif.Orientation != nil {
+		expected, err := strconv.Atoi(*exif.Orientation)
+		if err == nil && normalOrientation(compressed.orientation) != normalOrientation(expected) {
+			mismatched = append(mismatched, "Orientation")
+		}
+	}
+	if original.make != "" && exif.Make != nil && compressed.make != strings.TrimSpace(*exif.Make) {
+		mismatched = append(mismatched, "Make")
+	}
+	if original.model != "" && exif.Model != nil && compressed.model != strings.TrimSpace(*exif.Model) {
+		mismatched = append(mismatched, "Model")
+	}
+	if original.hasICC && !compressed.hasICC {
+		mismatched = append(mismatched, "ICC profile")
+	}
+	return mismatched
+}
+
+// normalOrientation treats a missing orientation as the default one
+func normalOrientation(orientation int) int {
+	if orientation == 0 {
+		return 1
+	}
+	return orientation
+}
+
+// exifLocation returns the time zone Immich read for the asset. Immich uses
+// IANA names or offsets like UTC+2 and UTC+05:30, without one the EXIF date
+// is taken as UTC.
+func exifLocation(timeZone *string) *time.Location {
+	if timeZone == nil || *timeZone == "" {
+		return time.UTC
+	}
+	if location, err := time.LoadLocation(*timeZone); err == nil {
+		return location
+	}
+
+	offset := strings.TrimPrefix(*timeZone, "UTC")
+	if offset == "" || (offset[0] != '+' && offset[0] != '-') {
+		return time.UTC
+	}
+	sign := 1
+	if offset[0] == '-' {
+		sign = -1
+	}
+	hours, minutes, _ := strings.Cut(offset[1:], ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return time.UTC
+	}
+	m := 0
+	if minutes != "" {
+		m, err = strconv.Atoi(minutes)
+		if err != nil {
+			return time.UTC
+		}
+	}
+	return time.FixedZone(*timeZone, sign*(h*3600+m*60))
+}
@@ -0,0 +1,136 @@
+package compress
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"immich-compress/immich"
+)
+
+func TestExifValue(t *testing.T) {
+	tests := map[string]string{
+		"Apple (Apple, ASCII, 6 components, 6 bytes)":                                     "Apple",
+		"2024:05:01 10:20:30 (2024:05:01 10:20:30, ASCII, 20 components, 20 bytes)":       "2024:05:01 10:20:30",
+		"Canon EOS 5D (Mark II) (Canon EOS 5D (Mark II), ASCII, 23 components, 23 bytes)": "Canon EOS 5D (Mark II)",
+		"plain": "plain",
+	}
+	for value, expected := range tests {
+		if got := exifValue(value); got != expected {
+			t.Errorf("exifValue(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+}
+
+func TestParseGPS(t *testing.T) {
+	degrees, err := parseGPS("52, 31, 12.00", "N")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(degrees-52.52) > 1e-9 {
+		t.Errorf("Expected 52.52, got %v", degrees)
+	}
+
+	degrees, err = parseGPS("13, 24, 18.00", "W")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if math.Abs(degrees+13.405) > 1e-9 {
+		t.Errorf("Expected -13.405, got %v", degrees)
+	}
+
+	if _, err := parseGPS("", ""); err == nil {
+		t.Error("Expected error for missing coordinate, got nil")
+	}
+}
+
+func TestExifLocation(t *testing.T) {
+	instant := time.Date(2024, 5, 1, 8, 20, 30, 0, time.UTC)
+	tests := []struct {
+		timeZone *string
+		expected string
+	}{
+		{timeZone: nil, expected: "2024:05:01 08:20:30"},
+		{timeZone: ptr("UTC+2"), expected: "2024:05:01 10:20:30"},
+		{timeZone: ptr("UTC-05:30"), expected: "2024:05:01 02:50:30"},
+		{timeZone: ptr("Europe/Berlin"), expected: "2024:05:01 10:20:30"},
+		{timeZone: ptr("garbage"), expected: "2024:05:01 08:20:30"},
+	}
+	for _, tt := range tests {
+		if got := instant.In(exifLocation(tt.timeZone)).Format(exifDateLayout); got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+		}
+	}
+}
+
+func TestVerifyImageMetadata(t *testing.T) {
+	dateTimeOriginal := time.Date(2024, 5, 1, 8, 20, 30, 0, time.UTC)
+	var latitude, longitude float32 = 52.52, 13.405
+	exif := immich.ExifResponseDto{
+		DateTimeOriginal: &dateTimeOriginal,
+		TimeZone:         ptr("UTC+2"),
+		Latitude:         &latitude,
+		Longitude:        &longitude,
+		Orientation:      ptr("6"),
+		Make:             ptr("Apple"),
+		Model:            ptr("iPhone 13"),
+	}
+	original := imageMetadata{
+		dateTimeOriginal: "2024:05:01 10:20:30",
+		hasGPS:           true,
+		latitude:         52.52,
+		longitude:        13.405,
+		orientation:      6,
+		make:             "Apple",
+		model:            "iPhone 13",
+		hasICC:           true,
+	}
+
+	tests := []struct {
+		name       string
+		original   imageMetadata
+		compressed imageMetadata
+		expected   []string
+	}{
+		{
+			name:       "everything kept",
+			original:   original,
+			compressed: original,
+		},
+		{
+			name:       "everything lost",
+			original:   original,
+			compressed: imageMetadata{},
+			expected:   []string{"DateTimeOriginal", "GPS", "Orientation", "Make", "Model", "ICC profile"},
+		},
+		{
+			name:     "moved location",
+			original: original,
+			compressed: func() imageMetadata {
+				moved := original
+				moved.latitude += 0.01
+				return moved
+			}(),
+			expected: []string{"GPS"},
+		},
+		{
+			name:       "fields missing in the original are not checked",
+			original:   imageMetadata{orientation: 6},
+			compressed: imageMetadata{orientation: 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mismatched := verifyImageMetadata(tt.original, tt.compressed, exif)
+			if !slices.Equal(mismatched, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, mismatched)
+			}
+		})
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -216,7 +216,7 @@ func TestErrorPropagationInWorkflow(t *testing.T) {
 
 		// Test format validation without actual processing
 		switch imageConfig.Format {
-		case JPEG, JPG, JXL, WEBP, HEIF:
+		case JPEG, JPG, JXL, WEBP, HEIF, AVIF:
 			// This would be handled in actual processing
 		default:
 			// Invalid format correctly identified
@@ -0,0 +1,145 @@
+package compress
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Stage is a step of replacing a single asset that is recorded in the journal
+type Stage string
+
+const (
+	StageDownloaded Stage = "downloaded"
+	StageEncoded    Stage = "encoded"
+	StageSkipped    Stage = "skipped"
+	StageUploaded   Stage = "uploaded"
+	StageTagged     Stage = "tagged"
+	StageDeleted    Stage = "deleted"
+)
+
+// journalEntry is one line of the journal file
+type journalEntry struct {
+	AssetID string    `json:"assetId"`
+	Stage   Stage     `json:"stage"`
+	NewID   string    `json:"newId,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// journal is an append-only on-disk log of the stage every asset reached.
+// Every line is a JSON encoded journalEntry, the last line for an asset wins.
+// A nil *journal is valid and records nothing.
+type journal struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]journalEntry
+}
+
+// openJournal opens the journal at path. With resume the existing entries
+// are loaded, otherwise the file is truncated and a new run starts. A journal
+// with uploaded but unfinished assets is only truncated with discard, their
+// half-done replacements could not be resumed anymore.
+func openJournal(path string, resume, discard bool) (*journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file: %w", err)
+	}
+
+	j := &journal{file: file, entries: map[string]journalEntry{}}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave a half written last line behind
+			continue
+		}
+		if entry.NewID == "" {
+			entry.NewID = j.entries[entry.AssetID].NewID
+		}
+		j.entries[entry.AssetID] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if !resume {
+		if unfinished := j.unfinished(); unfinished > 0 && !discard {
+			file.Close()
+			return nil, fmt.Errorf("state file %s has %d uploaded but unfinished assets, continue them with --resume or discard them with --discard-state", path, unfinished)
+		}
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to truncate state file: %w", err)
+		}
+		j.entries = map[string]journalEntry{}
+	}
+
+	return j, nil
+}
+
+// unfinished returns the number of assets whose copy reached the server but
+// did not replace the original yet. Downloaded and encoded assets left
+// nothing on the server and simply start over.
+func (j *journal) unfinished() int {
+	count := 0
+	for _, entry := range j.entries {
+		if entry.Stage == StageUploaded || entry.Stage == StageTagged {
+			count++
+		}
+	}
+	return count
+}
+
+// record appends the stage an asset reached and syncs it to disk
+func (j *journal) record(assetID string, stage Stage, newID string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry := journalEntry{AssetID: assetID, Stage: stage, NewID: newID, Time: time.Now()}
+	if entry.NewID == "" {
+		entry.NewID = j.entries[assetID].NewID
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	j.entries[assetID] = entry
+
+	return nil
+}
+
+// entry returns the last recorded entry of an asset
+func (j *journal) entry(assetID string) (journalEntry, bool) {
+	if j == nil {
+		return journalEntry{}, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.entries[assetID]
+	return entry, ok
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,174 @@
+package compress
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalRecordAndResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "journal.jsonl")
+
+	j, err := openJournal(path, false, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	if err := j.record("asset-1", StageDownloaded, ""); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if err := j.record("asset-1", StageUploaded, "new-1"); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if err := j.record("asset-1", StageTagged, ""); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if err := j.record("asset-2", StageSkipped, ""); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if err := j.close(); err != nil {
+		t.Fatalf("Failed to close journal: %v", err)
+	}
+
+	j, err = openJournal(path, true, false)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.close()
+
+	entry, ok := j.entry("asset-1")
+	if !ok {
+		t.Fatal("Expected entry for asset-1")
+	}
+	if entry.Stage != StageTagged {
+		t.Errorf("Expected stage %s, got %s", StageTagged, entry.Stage)
+	}
+	if entry.NewID != "new-1" {
+		t.Errorf("Expected new id to be carried over, got %q", entry.NewID)
+	}
+
+	entry, ok = j.entry("asset-2")
+	if !ok || entry.Stage != StageSkipped {
+		t.Errorf("Expected asset-2 to be skipped, got %+v", entry)
+	}
+
+	if _, ok := j.entry("asset-3"); ok {
+		t.Error("Expected no entry for unknown asset")
+	}
+}
+
+func TestJournalWithoutResumeStartsOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j, err := openJournal(path, false, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	if err := j.record("asset-1", StageDeleted, "new-1"); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	j.close()
+
+	j, err = openJournal(path, false, false)
+	if err != nil {
+		t.Fatalf("Failed to reopen journal: %v", err)
+	}
+	defer j.close()
+
+	if _, ok := j.entry("asset-1"); ok {
+		t.Error("Expected journal to be truncated without resume")
+	}
+}
+
+func TestJournalWithoutResumeKeepsUnfinished(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j, err := openJournal(path, false, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	if err := j.record("asset-1", StageUploaded, "new-1"); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	j.close()
+
+	if _, err := openJournal(path, false, false); err == nil {
+		t.Fatal("Expected an error for a journal with unfinished assets")
+	}
+	j, err = openJournal(path, true, false)
+	if err != nil {
+		t.Fatalf("Expected the refused journal to be kept, got %v", err)
+	}
+	if entry, ok := j.entry("asset-1"); !ok || entry.Stage != StageUploaded {
+		t.Errorf("Expected the unfinished asset, got %+v", entry)
+	}
+	j.close()
+
+	j, err = openJournal(path, false, true)
+	if err != nil {
+		t.Fatalf("Expected discard to start over, got %v", err)
+	}
+	defer j.close()
+	if _, ok := j.entry("asset-1"); ok {
+		t.Error("Expected the discarded journal to be truncated")
+	}
+}
+
+func TestJournalWithoutResumeDiscardsLocalStages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j, err := openJournal(path, false, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	// Interrupted before anything reached the server
+	if err := j.record("asset-1", StageDownloaded, ""); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if err := j.record("asset-2", StageEncoded, ""); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	j.close()
+
+	j, err = openJournal(path, false, false)
+	if err != nil {
+		t.Fatalf("Expected downloaded and encoded assets to be discarded, got %v", err)
+	}
+	defer j.close()
+	if _, ok := j.entry("asset-2"); ok {
+		t.Error("Expected the journal to be truncated")
+	}
+}
+
+func TestJournalIgnoresTruncatedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	content := `{"assetId":"asset-1","stage":"uploaded","newId":"new-1","time":"2024-01-01T12:00:00Z"}
+{"assetId":"asset-1","stage":"tag`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write journal: %v", err)
+	}
+
+	j, err := openJournal(path, true, false)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer j.close()
+
+	entry, ok := j.entry("asset-1")
+	if !ok || entry.Stage != StageUploaded || entry.NewID != "new-1" {
+		t.Errorf("Expected last complete entry to win, got %+v", entry)
+	}
+}
+
+func TestJournalNil(t *testing.T) {
+	var j *journal
+
+	if err := j.record("asset-1", StageDownloaded, ""); err != nil {
+		t.Errorf("Expected nil journal to ignore records, got %v", err)
+	}
+	if _, ok := j.entry("asset-1"); ok {
+		t.Error("Expected nil journal to have no entries")
+	}
+	if err := j.close(); err != nil {
+		t.Errorf("Expected nil journal to close cleanly, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// jpegMagic starts every JPEG file (SOI marker followed by the next marker)
+var jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+
+// transcodeJPEG repacks a JPEG into JPEG XL with the lossless recompression
+// of libjxl. The DCT coefficients are kept as they are, nothing is decoded or
+// re-encoded, and the result is only accepted when djxl rebuilds the
+// original bytes from it.
+func transcodeJPEG(ctx context.Context, fileIn, fileOutPath string) (*compressed, error) {
+	isJPEG, err := isJPEGFile(fileIn)
+	if err != nil {
+		return nil, err
+	}
+	if !isJPEG {
+		return nil, skipAsset("not a JPEG, lossless transcoding needs one")
+	}
+
+	// --lossless_jpeg=1: keep the JPEG bitstream reconstruction data
+	// --effort=9: slowest and smallest, there is no quality to trade
+	output, err := exec.CommandContext(ctx, "cjxl", fileIn, fileOutPath, "--lossless_jpeg=1", "--effort=9").CombinedOutput()
+	if err != nil {
+		os.Remove(fileOutPath)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// Arithmetic coded or broken JPEGs can not be repacked
+			return nil, skipAsset("cjxl can not transcode: %s", lastLine(output))
+		}
+		return nil, fmt.Errorf("cjxl failed with output '%s' %w", string(output), err)
+	}
+
+	err = verifyJPEGReconstruction(ctx, fileIn, fileOutPath)
+	if err != nil {
+		os.Remove(fileOutPath)
+		return nil, err
+	}
+
+	fileOut, err := os.Open(fileOutPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open temp output file: %w", err)
+	}
+	return &compressed{file: fileOut}, nil
+}
+
+// verifyJPEGReconstruction rebuilds the JPEG from the JPEG XL file and
+// rejects the transcode when it differs from the original in a single byte
+func verifyJPEGReconstruction(ctx context.Context, fileJPEG, fileJXL string) error {
+	fileRebuilt := fileJXL + ".jpg"
+	defer os.Remove(fileRebuilt)
+
+	output, err := exec.CommandContext(ctx, "djxl", fileJXL, fileRebuilt).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("djxl failed with output '%s' %w", string(output), err)
+	}
+
+	same, err := sameContent(fileJPEG, fileRebuilt)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return skipAsset("JPEG reconstruction is not bit-exact")
+	}
+	return nil
+}
+
+// isJPEGFile reports whether the file starts like a JPEG
+func isJPEGFile(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, len(jpegMagic))
+	_, err = io.ReadFull(f, head)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read image: %w", err)
+	}
+	return bytes.Equal(head, jpegMagic), nil
+}
+
+// sameContent reports whether two files hold the same bytes. The files are
+// compared a chunk at a time and the reading stops at the first difference,
+// large originals are never held in memory as a whole.
+func sameContent(fileA, fileB string) (bool, error) {
+	a, err := os.Open(fileA)
+	if err != nil {
+		return false, fmt.Errorf("failed to read '%s': %w", fileA, err)
+	}
+	defer a.Close()
+	b, err := os.Open(fileB)
+	if err != nil {
+		return false, fmt.Errorf("failed to read '%s': %w", fileB, err)
+	}
+	defer b.Close()
+
+	infoA, err := a.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to read '%s': %w", fileA, err)
+	}
+	infoB, err := b.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to read '%s': %w", fileB, err)
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, len(bufA))
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		if errA != nil && !errors.Is(errA, io.EOF) && !errors.Is(errA, io.ErrUnexpectedEOF) {
+			return false, fmt.Errorf("failed to read '%s': %w", fileA, errA)
+		}
+		nB, errB := io.ReadFull(b, bufB)
+		if errB != nil && !errors.Is(errB, io.EOF) && !errors.Is(errB, io.ErrUnexpectedEOF) {
+			return false, fmt.Errorf("failed to read '%s': %w", fileB, errB)
+		}
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA != nil || errB != nil {
+			// A short read is the end of a file, both have to end together
+			return errA != nil && errB != nil, nil
+		}
+	}
+}
+
+// lastLine returns the last non empty line of a command output
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,148 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsJPEGFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		content  []byte
+		expected bool
+	}{
+		{name: "jpeg", content: []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00}, expected: true},
+		{name: "png", content: []byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A}},
+		{name: "short", content: []byte{0xFF, 0xD8}},
+		{name: "empty", content: []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(file, tt.content, 0o644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			got, err := isJPEGFile(file)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("isJPEGFile() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	c := filepath.Join(dir, "c")
+	for file, content := range map[string]string{a: "same", b: "same", c: "diff"} {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	if same, err := sameContent(a, b); err != nil || !same {
+		t.Errorf("Expected equal files, got %v, %v", same, err)
+	}
+	if same, err := sameContent(a, c); err != nil || same {
+		t.Errorf("Expected different files, got %v, %v", same, err)
+	}
+	// Larger than one chunk, differing only in the last byte
+	large := bytes.Repeat([]byte{0xAB}, 200*1024)
+	d := filepath.Join(dir, "d")
+	e := filepath.Join(dir, "e")
+	if err := os.WriteFile(d, large, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	large[len(large)-1] = 0xAC
+	if err := os.WriteFile(e, large, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if same, err := sameContent(d, e); err != nil || same {
+		t.Errorf("Expected different large files, got %v, %v", same, err)
+	}
+	if same, err := sameContent(d, d); err != nil || !same {
+		t.Errorf("Expected a large file to equal itself, got %v, %v", same, err)
+	}
+	if same, err := sameContent(a, d); err != nil || same {
+		t.Errorf("Expected files of different sizes to differ, got %v, %v", same, err)
+	}
+	if _, err := sameContent(a, filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	if got := lastLine([]byte("JPEG XL encoder\nError: unsupported\n\n")); got != "Error: unsupported" {
+		t.Errorf("Expected last line, got %q", got)
+	}
+}
+
+func TestImageFormatExtension(t *testing.T) {
+	if got := JXLLosslessJPEG.extension(); got != "jxl" {
+		t.Errorf("Expected jxl, got %s", got)
+	}
+	if got := WEBP.extension(); got != "webp" {
+		t.Errorf("Expected webp, got %s", got)
+	}
+}
+
+func TestTranscodeJPEGSkipsOtherFormats(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "shot.png")
+	if err := os.WriteFile(file, []byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A}, 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	_, err := transcodeJPEG(context.Background(), file, file+".jxl")
+	var skip *skipError
+	if !errors.As(err, &skip) {
+		t.Errorf("Expected a skip error, got %v", err)
+	}
+}
+
+func TestTranscodeJPEGRoundTrip(t *testing.T) {
+	for _, tool := range []string{"cjxl", "djxl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available for testing", tool)
+		}
+	}
+
+	dir := t.TempDir()
+	fileIn := filepath.Join(dir, "photo.jpg")
+	f, err := os.Create(fileIn)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	f.Close()
+
+	result, err := transcodeJPEG(context.Background(), fileIn, filepath.Join(dir, "photo.jxl"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer result.file.Close()
+	if filepath.Ext(result.file.Name()) != ".jxl" {
+		t.Errorf("Expected a .jxl file, got %s", result.file.Name())
+	}
+}
@@ -3,61 +3,183 @@ package compress
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"runtime"
 	"slices"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"immich-compress/immich"
 
-	"github.com/google/uuid"
+	"github.com/oapi-codegen/runtime/types"
 	"golang.org/x/sync/errgroup"
 )
 
 // Config holds configuration for compression
 type Config struct {
-	Parallel       int
-	Limit          int
-	AssetType      string
-	AssetUUIDs     []string
-	Server         string
-	APIKey         string
-	After          time.Time
-	DiffPercent    int
-	ImageFormat    ImageFormat
-	ImageQuality   int
-	VideoContainer VideoContainer
-	VideoFormat    VideoFormat
-	VideoQuality   int
+	Parallel          int
+	Limit             int
+	AssetType         string
+	AssetUUIDs        []string
+	Server            string
+	APIKey            string
+	After             time.Time
+	DiffPercent       int
+	ImageFormat       ImageFormat
+	ImageQuality      int
+	ImageMinSSIM      float64
+	ImageTargetSSIM   float64
+	ImageAVIF         AVIFOptions
+	ImageMaxDimension int
+	VideoContainer    VideoContainer
+	VideoFormat       VideoFormat
+	VideoQuality      int
+	VideoTargetVMAF   float64
+	VideoMinCRF       int
+	VideoMaxCRF       int
+	VideoMaxHeight    int
+	VideoMaxFPS       float64
+	AudioTracks       AudioTracks
+	AudioChannels     int
+	AudioCopy         bool
+	DataStreams       DataStreams
+	VideoMinBPP       float64
+	VideoHDR          HDRMode
+	DryRun            bool
+	StateFile         string
+	Resume            bool
+	DiscardState      bool
+	StackPolicy       StackPolicy
+	Selection         Selection
+	MinSize           int64
+	Largest           int
+	PolicyFile        string
+	// KeepGoing records the errors of single assets instead of stopping the
+	// run, until MaxFailures assets failed (0 for no limit)
+	KeepGoing   bool
+	MaxFailures int
+	// Workers limits the stages of the pipeline, unset limits derive from
+	// Parallel and the CPU count
+	Workers Workers
+	// Retry repeats Immich requests that failed with a transient error
+	Retry immich.Retry
 }
 
 func Compressing(ctx context.Context, config Config) error {
+	if !slices.Contains(StackPoliciesAvailable, config.StackPolicy) {
+		return fmt.Errorf("unknown stack policy: %s", config.StackPolicy)
+	}
+	if !slices.Contains(AudioTracksAvailable, config.AudioTracks) {
+		return fmt.Errorf("unknown audio tracks: %s", config.AudioTracks)
+	}
+	if !slices.Contains(DataStreamsAvailable, config.DataStreams) {
+		return fmt.Errorf("unknown data streams: %s", config.DataStreams)
+	}
+	if !slices.Contains(HDRModesAvailable, config.VideoHDR) {
+		return fmt.Errorf("unknown hdr mode: %s", config.VideoHDR)
+	}
+	if err := config.Selection.validate(); err != nil {
+		return err
+	}
+	if err := config.Workers.validate(); err != nil {
+		return err
+	}
+	if config.MaxFailures < 0 {
+		return fmt.Errorf("max failures can not be negative")
+	}
+	if config.Largest > immich.LargeAssetsMax {
+		return fmt.Errorf("largest can be at most %d", immich.LargeAssetsMax)
+	}
+	assetIDs, err := parseUUIDs(config.AssetUUIDs)
+	if err != nil {
+		return err
+	}
+	// The assets are fetched directly, the search filters would be ignored
+	if len(assetIDs) > 0 && (!config.Selection.empty() || config.MinSize > 0 || config.Largest > 0) {
+		return fmt.Errorf("uuid can not be combined with the selection flags, min size or largest")
+	}
+	var policy *Policy
+	if config.PolicyFile != "" {
+		policy, err = LoadPolicy(config.PolicyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Resume && config.StateFile == "" {
+		return fmt.Errorf("resume needs a state file")
+	}
+	var journal *journal
+	if config.StateFile != "" && !config.DryRun {
+		journal, err = openJournal(config.StateFile, config.Resume, config.DiscardState)
+		if err != nil {
+			return err
+		}
+		defer journal.close()
+	}
+
+	workers := config.Workers.withDefaults(config.Parallel, runtime.NumCPU())
+	stages := newStages(workers)
+	fmt.Printf("Workers: %d download, %d image, %d video (%d threads each), %d upload\n",
+		workers.Download, workers.Image, workers.Video, workers.VideoThreads, workers.Upload)
+
+	// Every asset in flight holds a worker or waits for the next stage,
+	// g.Go blocks the search once the queues are full
 	g, gCtx := errgroup.WithContext(ctx)
-	g.SetLimit(config.Parallel)
-	client, err := immich.NewClientSimple(gCtx, config.Parallel, config.Server, config.APIKey)
+	g.SetLimit(workers.inFlight())
+	client, err := immich.NewClientSimple(gCtx, config.Parallel, config.Server, config.APIKey, config.Retry)
+	if err != nil {
+		return err
+	}
+
+	if !config.DryRun {
+		runID := time.Now().Format("20060102-150405")
+		err = client.TagRunSet(runID)
+		if err != nil {
+			return fmt.Errorf("can not create run tag: %w", err)
+		}
+		fmt.Printf("Run ID: %s\n", runID)
+	}
+
+	err = client.StacksLoad()
 	if err != nil {
 		return err
 	}
 
+	var failed *failures
+	if config.KeepGoing {
+		failed = &failures{max: config.MaxFailures}
+	}
 	var counter int32 = 0
+	var savedBytes int64 = 0
 
 	searchOption := immich.SearchAssetsJSONRequestBody{}
 	if config.AssetType != "ALL" {
 		typeAsset := (immich.AssetTypeEnum)(config.AssetType)
 		searchOption.Type = &typeAsset
 	}
-	if len(config.AssetUUIDs) == 1 {
-		UUIDstring := config.AssetUUIDs[0]
-		UUID, err := uuid.Parse(UUIDstring)
-		if err != nil {
-			return err
-		}
-		searchOption.Id = &UUID
+	err = config.Selection.apply(client, &searchOption)
+	if err != nil {
+		return err
+	}
+	var ch <-chan struct {
+		Asset immich.AssetResponseDto
+		Err   error
+	}
+	if len(assetIDs) > 0 {
+		// Fetch the assets directly, searching would scan the whole library
+		ch = client.AssetsByID(config.Limit, assetIDs)
+	} else if config.MinSize > 0 || config.Largest > 0 {
+		// Largest first, so a limited run saves the most
+		ch = client.AssetSearchLarge(config.Limit, config.Largest, config.MinSize, searchOption)
+	} else {
+		ch = client.AssetSearch(config.Limit, searchOption)
 	}
-	ch := client.AssetSearch(config.Limit, searchOption)
 	// Start the workers. Instead of 'for range parallel', we simply
 	// read from the channel and run g.Go() for *each* element.
-	// SetLimit(parallel) will take care of the limit.
+	// SetLimit() and the stages take care of the limits.
 	for asset := range ch {
 		// Pass 'asset' to the closure to avoid race conditions
 		asset := asset
@@ -73,13 +195,26 @@ func Compressing(ctx context.Context, config Config) error {
 			}
 
 			if asset.Err != nil {
-				// Just return the error.
+				// A single asset that can not be fetched is a failure of
+				// that asset, any other error of the search stops the run.
 				// errgroup will automatically call cancel() for gCtx.
+				var assetErr *immich.AssetError
+				if errors.As(asset.Err, &assetErr) {
+					return failed.handle(gCtx, asset.Asset, "fetch", asset.Err)
+				}
 				return asset.Err
 			}
 
-			if len(config.AssetUUIDs) > 0 {
-				if !slices.Contains(config.AssetUUIDs, asset.Asset.Id) {
+			if searchOption.Type != nil && asset.Asset.Type != *searchOption.Type {
+				return nil
+			}
+
+			if config.Resume {
+				done, err := resumeAsset(client, journal, asset.Asset)
+				if err != nil {
+					return failed.handle(gCtx, asset.Asset, "resume", err)
+				}
+				if done {
 					return nil
 				}
 			}
@@ -87,20 +222,52 @@ func Compressing(ctx context.Context, config Config) error {
 			if !asset.Asset.CompressedAfter(config.After) {
 				return nil
 			}
+			if asset.Asset.Stack == nil {
+				asset.Asset.Stack = client.StackOf(asset.Asset.Id)
+			}
+			if !config.StackPolicy.allows(asset.Asset) {
+				return nil
+			}
+			imageConfig := ImageConfig{
+				Format:           config.ImageFormat,
+				Quality:          config.ImageQuality,
+				MinSimilarity:    config.ImageMinSSIM,
+				TargetSimilarity: config.ImageTargetSSIM,
+				MaxDimension:     config.ImageMaxDimension,
+				AVIF:             config.ImageAVIF,
+			}
+			videoConfig := VideoConfig{
+				Container:       config.VideoContainer,
+				Format:          config.VideoFormat,
+				Quality:         config.VideoQuality,
+				TargetVMAF:      config.VideoTargetVMAF,
+				MinCRF:          config.VideoMinCRF,
+				MaxCRF:          config.VideoMaxCRF,
+				MaxHeight:       config.VideoMaxHeight,
+				MaxFPS:          config.VideoMaxFPS,
+				AudioTracks:     config.AudioTracks,
+				AudioChannels:   config.AudioChannels,
+				AudioCopy:       config.AudioCopy,
+				DataStreams:     config.DataStreams,
+				MinBitsPerPixel: config.VideoMinBPP,
+				HDR:             config.VideoHDR,
+				Threads:         workers.VideoThreads,
+			}
+			if rule := policy.match(asset.Asset); rule != nil {
+				if rule.Skip {
+					fmt.Printf("✗ Skipped: %s (policy rule '%s')\n", asset.Asset.OriginalFileName, rule.Name)
+					return nil
+				}
+				rule.apply(&imageConfig, &videoConfig)
+			}
 			// Process the asset here
 			fmt.Printf("Processing file: %#v\n", asset.Asset.Id)
-			err = compressFile(gCtx, client, asset.Asset, config.DiffPercent, ImageConfig{
-				Format:  config.ImageFormat,
-				Quality: config.ImageQuality,
-			}, VideoConfig{
-				Container: config.VideoContainer,
-				Format:    config.VideoFormat,
-				Quality:   config.VideoQuality,
-			})
+			saved, err := compressFile(gCtx, client, journal, stages, asset.Asset, config.DiffPercent, config.DryRun, imageConfig, videoConfig)
 			if err != nil {
-				return err
+				return failed.handle(gCtx, asset.Asset, "replace", err)
 			}
 			atomic.AddInt32(&counter, 1)
+			atomic.AddInt64(&savedBytes, saved)
 
 			return nil
 		})
@@ -111,10 +278,34 @@ func Compressing(ctx context.Context, config Config) error {
 	// any of the goroutines.
 	if err := g.Wait(); err != nil {
 		// If there was an error (including cancellation), we return it
+		failed.report()
 		return err
 	}
 
 	fmt.Printf("Processed files: %d\n", counter)
+	if config.DryRun {
+		fmt.Printf("Projected savings: %.2f MB (dry run, nothing was changed)\n", bytesToMB(savedBytes))
+	} else {
+		fmt.Printf("Saved: %.2f MB\n", bytesToMB(savedBytes))
+	}
+
+	return failed.report()
+}
 
-	return nil
+// parseUUIDs parses asset ids and drops duplicates
+func parseUUIDs(ids []string) ([]types.UUID, error) {
+	uuids := make([]types.UUID, 0, len(ids))
+	seen := make(map[types.UUID]bool, len(ids))
+	for _, id := range ids {
+		uuid, err := immich.UUUIDOfString(strings.TrimSpace(id))
+		if err != nil {
+			return nil, err
+		}
+		if seen[uuid] {
+			continue
+		}
+		seen[uuid] = true
+		uuids = append(uuids, uuid)
+	}
+	return uuids, nil
 }
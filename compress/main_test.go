@@ -0,0 +1,137 @@
+package compress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"immich-compress/immich"
+
+	"github.com/google/uuid"
+)
+
+func TestParseUUIDs(t *testing.T) {
+	ids := []string{
+		"0f8fad5b-d9cb-469f-a165-70867728950e",
+		" 7c9e6679-7425-40de-944b-e07fc1f90ae7 ",
+		"0f8fad5b-d9cb-469f-a165-70867728950e",
+	}
+	uuids, err := parseUUIDs(ids)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(uuids) != 2 {
+		t.Fatalf("Expected duplicates to be dropped, got %v", uuids)
+	}
+	if uuids[1].String() != "7c9e6679-7425-40de-944b-e07fc1f90ae7" {
+		t.Errorf("Unexpected uuid %s", uuids[1])
+	}
+
+	if _, err := parseUUIDs([]string{"not-a-uuid"}); err == nil {
+		t.Error("Expected error for invalid uuid, got nil")
+	}
+}
+
+// dryRunConfig is a valid configuration against the fake server
+func dryRunConfig(server string) Config {
+	return Config{
+		Parallel:    2,
+		AssetType:   "ALL",
+		Server:      server,
+		APIKey:      "key",
+		DryRun:      true,
+		StackPolicy: StackAll,
+		AudioTracks: AudioTracksFirst,
+		DataStreams: DataStreamsDrop,
+		VideoHDR:    HDRSkip,
+	}
+}
+
+func TestCompressingDryRunWritesNothing(t *testing.T) {
+	server := newFakeServer(t)
+	server.JSON("GET /stacks", http.StatusOK, []immich.StackResponseDto{})
+	server.JSON("POST /search/metadata", http.StatusOK, searchResult())
+
+	if err := Compressing(context.Background(), dryRunConfig(server.URL)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The metadata search is a POST that only reads
+	for _, r := range server.All() {
+		if r.Method != http.MethodGet && r.Path != "/search/metadata" {
+			t.Errorf("Expected a dry run to only read, got %s %s", r.Method, r.Path)
+		}
+	}
+	if len(server.Received(http.MethodPost, "/search/metadata")) == 0 {
+		t.Error("Expected the library to be searched")
+	}
+}
+
+func TestCompressingCreatesRunTag(t *testing.T) {
+	server := newFakeServer(t)
+	server.JSON("GET /stacks", http.StatusOK, []immich.StackResponseDto{})
+	server.JSON("POST /search/metadata", http.StatusOK, searchResult())
+	server.JSON("GET /tags", http.StatusOK, []immich.TagResponseDto{})
+	server.Handle("POST /tags", func(w http.ResponseWriter, r *http.Request) {
+		var create immich.CreateTagJSONRequestBody
+		_ = json.NewDecoder(r.Body).Decode(&create)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(immich.TagResponseDto{Id: uuid.NewString(), Name: create.Name})
+	})
+
+	config := dryRunConfig(server.URL)
+	config.DryRun = false
+	if err := Compressing(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(server.Received(http.MethodPost, "/tags")) == 0 {
+		t.Error("Expected a real run to create its run tag")
+	}
+}
+
+func TestCompressingRejectsFiltersWithUUID(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*Config)
+	}{
+		{"selection", func(c *Config) { c.Selection.Albums = []string{"Trip"} }},
+		{"min size", func(c *Config) { c.MinSize = 1 << 20 }},
+		{"largest", func(c *Config) { c.Largest = 10 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			config := dryRunConfig(server.URL)
+			config.AssetUUIDs = []string{"0f8fad5b-d9cb-469f-a165-70867728950e"}
+			tt.config(&config)
+			if err := Compressing(context.Background(), config); err == nil {
+				t.Error("Expected error, got nil")
+			}
+			if requests := server.All(); len(requests) > 0 {
+				t.Errorf("Expected no request, got %d", len(requests))
+			}
+		})
+	}
+}
+
+func TestCompressingKeepGoingMissingUUID(t *testing.T) {
+	missing := "0f8fad5b-d9cb-469f-a165-70867728950e"
+	for _, keepGoing := range []bool{false, true} {
+		server := newFakeServer(t)
+		server.JSON("GET /stacks", http.StatusOK, []immich.StackResponseDto{})
+		config := dryRunConfig(server.URL)
+		config.AssetUUIDs = []string{missing}
+		config.KeepGoing = keepGoing
+
+		err := Compressing(context.Background(), config)
+		if err == nil {
+			t.Fatalf("keep going %v: expected an error for the missing asset", keepGoing)
+		}
+		var assetErr *immich.AssetError
+		if stopped := errors.As(err, &assetErr); stopped == keepGoing {
+			t.Errorf("keep going %v: expected the run to stop only without keep going, got %v", keepGoing, err)
+		}
+	}
+}
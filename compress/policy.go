@@ -0,0 +1,330 @@
+package compress
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"immich-compress/immich"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy picks the compression settings per asset. The first rule whose
+// match fits the asset wins, assets without a matching rule use the
+// settings of the command line.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule either skips the matched assets or overrides the image and
+// video settings for them
+type PolicyRule struct {
+	Name  string       `yaml:"name"`
+	Match PolicyMatch  `yaml:"match"`
+	Skip  bool         `yaml:"skip"`
+	Image *PolicyImage `yaml:"image"`
+	Video *PolicyVideo `yaml:"video"`
+}
+
+// PolicyMatch describes the assets a rule applies to. All set fields have
+// to match, a list matches when one of its entries does.
+type PolicyMatch struct {
+	// MimeTypes are patterns like image/png or video/*
+	MimeTypes  []string `yaml:"mime"`
+	Extensions []string `yaml:"extension"`
+	Makes      []string `yaml:"make"`
+	Models     []string `yaml:"model"`
+	MinSize    string   `yaml:"minSize"`
+	MaxSize    string   `yaml:"maxSize"`
+	MinWidth   int      `yaml:"minWidth"`
+	MaxWidth   int      `yaml:"maxWidth"`
+	MinHeight  int      `yaml:"minHeight"`
+	MaxHeight  int      `yaml:"maxHeight"`
+
+	minSize, maxSize int64
+}
+
+// PolicyImage overrides the image settings, unset fields keep the settings
+// of the command line. Settings that 0 or false turns off are pointers, so a
+// rule can turn them off.
+type PolicyImage struct {
+	Format     ImageFormat `yaml:"format"`
+	Quality    int         `yaml:"quality"`
+	Lossless   *bool       `yaml:"lossless"`
+	MinSSIM    *float64    `yaml:"minSsim"`
+	TargetSSIM *float64    `yaml:"targetSsim"`
+	// MaxDimension caps the longer side
+	MaxDimension *int `yaml:"maxDimension"`
+}
+
+// PolicyVideo overrides the video settings, unset fields keep the settings
+// of the command line. Settings that 0 or false turns off are pointers, so a
+// rule can turn them off.
+type PolicyVideo struct {
+	Container  VideoContainer `yaml:"container"`
+	Format     VideoFormat    `yaml:"format"`
+	Quality    int            `yaml:"quality"`
+	TargetVMAF *float64       `yaml:"targetVmaf"`
+	// MaxHeight caps the shorter side
+	MaxHeight     *int        `yaml:"maxHeight"`
+	MaxFPS        *float64    `yaml:"maxFps"`
+	AudioTracks   AudioTracks `yaml:"audioTracks"`
+	AudioChannels *int        `yaml:"audioChannels"`
+	AudioCopy     *bool       `yaml:"audioCopy"`
+	DataStreams   DataStreams `yaml:"dataStreams"`
+	MinBPP        *float64    `yaml:"minBpp"`
+	HDR           HDRMode     `yaml:"hdr"`
+}
+
+// LoadPolicy reads and validates a YAML policy file
+func LoadPolicy(file string) (*Policy, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open policy file: %w", err)
+	}
+	defer f.Close()
+
+	var policy Policy
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file '%s': %w", file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file '%s': %w", file, err)
+	}
+	return &policy, nil
+}
+
+// validate checks the rules and parses their sizes
+func (p *Policy) validate() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule '%s': %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *PolicyRule) validate() error {
+	if r.Skip && (r.Image != nil || r.Video != nil) {
+		return fmt.Errorf("a skipping rule can not set image or video settings")
+	}
+	var err error
+	if r.Match.minSize, err = ParseSize(r.Match.MinSize); err != nil {
+		return err
+	}
+	if r.Match.maxSize, err = ParseSize(r.Match.MaxSize); err != nil {
+		return err
+	}
+	if r.Image != nil {
+		r.Image.Format = ImageFormat(strings.ToLower(string(r.Image.Format)))
+		if r.Image.Format != "" && !slices.Contains(ImageFormatsAvailable, r.Image.Format) {
+			return fmt.Errorf("unknown image format: %s", r.Image.Format)
+		}
+		if r.Image.Quality < 0 || r.Image.Quality > imageQualityMax {
+			return fmt.Errorf("image quality %d is out of range", r.Image.Quality)
+		}
+		if r.Image.MinSSIM != nil && (*r.Image.MinSSIM < 0 || *r.Image.MinSSIM > SimilarityMax) {
+			return fmt.Errorf("min ssim %g is out of range (0-%g)", *r.Image.MinSSIM, SimilarityMax)
+		}
+		if r.Image.TargetSSIM != nil && (*r.Image.TargetSSIM < 0 || *r.Image.TargetSSIM > SimilarityMax) {
+			return fmt.Errorf("target ssim %g is out of range (0-%g)", *r.Image.TargetSSIM, SimilarityMax)
+		}
+		if r.Image.MinSSIM != nil && r.Image.TargetSSIM != nil && *r.Image.TargetSSIM > 0 && *r.Image.TargetSSIM < *r.Image.MinSSIM {
+			return fmt.Errorf("target ssim %g is lower than min ssim %g", *r.Image.TargetSSIM, *r.Image.MinSSIM)
+		}
+		if r.Image.Lossless != nil && *r.Image.Lossless && (r.Image.Format == JPG || r.Image.Format == JPEG) {
+			return fmt.Errorf("%s can not be lossless", r.Image.Format)
+		}
+	}
+	if r.Video != nil {
+		r.Video.Format = VideoFormat(strings.ToLower(string(r.Video.Format)))
+		if r.Video.Format != "" && !slices.Contains(VideoFormatsAvailable, r.Video.Format) {
+			return fmt.Errorf("unknown video format: %s", r.Video.Format)
+		}
+		// These end up in the ffmpeg arguments, 0 turns them off
+		if (r.Video.MaxHeight != nil && *r.Video.MaxHeight < 0) || (r.Video.MaxFPS != nil && *r.Video.MaxFPS < 0) {
+			return fmt.Errorf("max height and max fps can not be negative")
+		}
+		if r.Video.AudioChannels != nil && *r.Video.AudioChannels < 0 {
+			return fmt.Errorf("audio channels can not be negative")
+		}
+		if r.Video.MinBPP != nil && *r.Video.MinBPP < 0 {
+			return fmt.Errorf("min bpp can not be negative")
+		}
+		if r.Video.TargetVMAF != nil && (*r.Video.TargetVMAF < 0 || *r.Video.TargetVMAF > VMAFMax) {
+			return fmt.Errorf("target vmaf %g is out of range (0-%g)", *r.Video.TargetVMAF, VMAFMax)
+		}
+		r.Video.Container = VideoContainer(strings.ToLower(string(r.Video.Container)))
+		if r.Video.Container != "" && !slices.Contains(VideoContainersAvailable, r.Video.Container) {
+			return fmt.Errorf("unknown video container: %s", r.Video.Container)
+		}
+		if r.Video.AudioTracks != "" && !slices.Contains(AudioTracksAvailable, r.Video.AudioTracks) {
+			return fmt.Errorf("unknown audio tracks: %s", r.Video.AudioTracks)
+		}
+		if r.Video.DataStreams != "" && !slices.Contains(DataStreamsAvailable, r.Video.DataStreams) {
+			return fmt.Errorf("unknown data streams: %s", r.Video.DataStreams)
+		}
+		if r.Video.HDR != "" && !slices.Contains(HDRModesAvailable, r.Video.HDR) {
+			return fmt.Errorf("unknown hdr mode: %s", r.Video.HDR)
+		}
+	}
+	return nil
+}
+
+// match returns the first rule that applies to the asset, nil when there is
+// no policy or no rule applies
+func (p *Policy) match(asset immich.AssetResponseDto) *PolicyRule {
+	if p == nil {
+		return nil
+	}
+	for i := range p.Rules {
+		if p.Rules[i].Match.matches(asset) {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+func (m *PolicyMatch) matches(asset immich.AssetResponseDto) bool {
+	if len(m.MimeTypes) > 0 {
+		mime := ""
+		if asset.OriginalMimeType != nil {
+			mime = strings.ToLower(*asset.OriginalMimeType)
+		}
+		if !slices.ContainsFunc(m.MimeTypes, func(pattern string) bool {
+			ok, _ := path.Match(strings.ToLower(pattern), mime)
+			return ok
+		}) {
+			return false
+		}
+	}
+	if len(m.Extensions) > 0 {
+		ext := strings.TrimPrefix(filepath.Ext(asset.OriginalFileName), ".")
+		if !slices.ContainsFunc(m.Extensions, func(e string) bool {
+			return strings.EqualFold(strings.TrimPrefix(e, "."), ext)
+		}) {
+			return false
+		}
+	}
+
+	exif := asset.ExifInfo
+	if exif == nil {
+		exif = &immich.ExifResponseDto{}
+	}
+	if !matchesName(m.Makes, exif.Make) || !matchesName(m.Models, exif.Model) {
+		return false
+	}
+	if m.minSize > 0 || m.maxSize > 0 {
+		if exif.FileSizeInByte == nil {
+			return false
+		}
+		size := *exif.FileSizeInByte
+		if (m.minSize > 0 && size < m.minSize) || (m.maxSize > 0 && size > m.maxSize) {
+			return false
+		}
+	}
+	if !inRange(exif.ExifImageWidth, m.MinWidth, m.MaxWidth) || !inRange(exif.ExifImageHeight, m.MinHeight, m.MaxHeight) {
+		return false
+	}
+	return true
+}
+
+// matchesName reports whether value is one of names, ignoring case. An empty
+// list matches everything.
+func matchesName(names []string, value *string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	return slices.ContainsFunc(names, func(name string) bool {
+		return strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(*value))
+	})
+}
+
+// inRange reports whether value lies within min and max, a bound of 0 is
+// not checked
+func inRange(value *float32, min, max int) bool {
+	if min == 0 && max == 0 {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	return (min == 0 || int(*value) >= min) && (max == 0 || int(*value) <= max)
+}
+
+// apply overrides the settings of the command line with those of the rule
+func (r *PolicyRule) apply(imageConfig *ImageConfig, videoConfig *VideoConfig) {
+	if image := r.Image; image != nil {
+		if image.Format != "" {
+			imageConfig.Format = image.Format
+		}
+		if image.Quality > 0 {
+			// The quality of a rule applies to every format
+			imageConfig.Quality = image.Quality
+			imageConfig.AVIF.Quality = 0
+		}
+		if image.Lossless != nil {
+			imageConfig.Lossless = *image.Lossless
+		}
+		if image.MinSSIM != nil {
+			imageConfig.MinSimilarity = *image.MinSSIM
+		}
+		if image.TargetSSIM != nil {
+			imageConfig.TargetSimilarity = *image.TargetSSIM
+		}
+		if image.MaxDimension != nil {
+			imageConfig.MaxDimension = *image.MaxDimension
+		}
+	}
+	if video := r.Video; video != nil {
+		if video.Container != "" {
+			videoConfig.Container = video.Container
+		}
+		if video.Format != "" {
+			videoConfig.Format = video.Format
+		}
+		if video.Quality > 0 {
+			videoConfig.Quality = video.Quality
+		}
+		if video.TargetVMAF != nil {
+			videoConfig.TargetVMAF = *video.TargetVMAF
+		}
+		if video.MaxHeight != nil {
+			videoConfig.MaxHeight = *video.MaxHeight
+		}
+		if video.MaxFPS != nil {
+			videoConfig.MaxFPS = *video.MaxFPS
+		}
+		if video.AudioTracks != "" {
+			videoConfig.AudioTracks = video.AudioTracks
+		}
+		if video.AudioChannels != nil {
+			videoConfig.AudioChannels = *video.AudioChannels
+		}
+		if video.AudioCopy != nil {
+			videoConfig.AudioCopy = *video.AudioCopy
+		}
+		if video.DataStreams != "" {
+			videoConfig.DataStreams = video.DataStreams
+		}
+		if video.MinBPP != nil {
+			videoConfig.MinBitsPerPixel = *video.MinBPP
+		}
+		if video.HDR != "" {
+			videoConfig.HDR = video.HDR
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package compress
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"immich-compress/immich"
+)
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	return file
+}
+
+func TestLoadPolicy(t *testing.T) {
+	file := writePolicy(t, `
+rules:
+  - name: screenshots
+    match:
+      mime: [image/png]
+    image:
+      format: JXL
+      lossless: true
+  - name: heic
+    match:
+      mime: [image/heic, image/heif]
+    skip: true
+  - match:
+      extension: [avi]
+      minSize: 10M
+    video:
+      format: av1
+      container: mkv
+      quality: 30
+`)
+	policy, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(policy.Rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %d", len(policy.Rules))
+	}
+	if policy.Rules[0].Image.Format != JXL {
+		t.Errorf("Expected format to be lower cased, got %s", policy.Rules[0].Image.Format)
+	}
+	if policy.Rules[2].Name != "#3" {
+		t.Errorf("Expected unnamed rule to be numbered, got %q", policy.Rules[2].Name)
+	}
+	if policy.Rules[2].Match.minSize != 10*1024*1024 {
+		t.Errorf("Expected minSize of 10M, got %d", policy.Rules[2].Match.minSize)
+	}
+}
+
+func TestLoadPolicyInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unknown field", content: "rules:\n  - match:\n      mimetype: [image/png]\n"},
+		{name: "unknown image format", content: "rules:\n  - image:\n      format: bmp\n"},
+		{name: "unknown video container", content: "rules:\n  - video:\n      container: avi\n"},
+		{name: "unknown audio tracks", content: "rules:\n  - video:\n      audioTracks: second\n"},
+		{name: "unknown data streams", content: "rules:\n  - video:\n      dataStreams: strip\n"},
+		{name: "unknown hdr mode", content: "rules:\n  - video:\n      hdr: keep\n"},
+		{name: "image quality out of range", content: "rules:\n  - image:\n      quality: 101\n"},
+		{name: "bad size", content: "rules:\n  - match:\n      maxSize: big\n"},
+		{name: "min ssim as percent", content: "rules:\n  - image:\n      minSsim: 95\n"},
+		{name: "negative target ssim", content: "rules:\n  - image:\n      targetSsim: -1\n"},
+		{name: "target ssim below min ssim", content: "rules:\n  - image:\n      minSsim: 0.95\n      targetSsim: 0.9\n"},
+		{name: "target vmaf too high", content: "rules:\n  - video:\n      targetVmaf: 150\n"},
+		{name: "negative target vmaf", content: "rules:\n  - video:\n      targetVmaf: -5\n"},
+		{name: "negative max fps", content: "rules:\n  - video:\n      maxFps: -30\n"},
+		{name: "negative min bpp", content: "rules:\n  - video:\n      minBpp: -0.1\n"},
+		{name: "negative max height", content: "rules:\n  - video:\n      maxHeight: -720\n"},
+		{name: "negative audio channels", content: "rules:\n  - video:\n      audioChannels: -2\n"},
+		{name: "lossless jpg", content: "rules:\n  - image:\n      format: jpg\n      lossless: true\n"},
+		{name: "lossless jpeg", content: "rules:\n  - image:\n      format: JPEG\n      lossless: true\n"},
+		{name: "skip with settings", content: "rules:\n  - skip: true\n    image:\n      quality: 50\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadPolicy(writePolicy(t, tt.content)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadPolicyMissingFile(t *testing.T) {
+	if _, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestPolicyMatch(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Name: "png", Match: PolicyMatch{MimeTypes: []string{"image/png"}}},
+		{Name: "camera", Match: PolicyMatch{Extensions: []string{".jpg", "jpeg"}, Makes: []string{"canon"}}},
+		{Name: "large video", Match: PolicyMatch{MimeTypes: []string{"video/*"}, MinHeight: 1080}},
+		{Name: "small", Match: PolicyMatch{MaxSize: "1M"}},
+	}}
+	if err := policy.validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		asset    immich.AssetResponseDto
+		expected string
+	}{
+		{
+			name:     "mime",
+			asset:    immich.AssetResponseDto{OriginalFileName: "shot.png", OriginalMimeType: ptr("image/png")},
+			expected: "png",
+		},
+		{
+			name: "extension and make",
+			asset: immich.AssetResponseDto{
+				OriginalFileName: "IMG_1.JPG",
+				ExifInfo:         &immich.ExifResponseDto{Make: ptr("Canon")},
+			},
+			expected: "camera",
+		},
+		{
+			name: "extension without make",
+			asset: immich.AssetResponseDto{
+				OriginalFileName: "IMG_1.JPG",
+				ExifInfo:         &immich.ExifResponseDto{FileSizeInByte: ptr(int64(5 * 1024 * 1024))},
+			},
+		},
+		{
+			name: "mime pattern and resolution",
+			asset: immich.AssetResponseDto{
+				OriginalFileName: "clip.mp4",
+				OriginalMimeType: ptr("video/mp4"),
+				ExifInfo:         &immich.ExifResponseDto{ExifImageHeight: ptr(float32(2160))},
+			},
+			expected: "large video",
+		},
+		{
+			name: "size",
+			asset: immich.AssetResponseDto{
+				OriginalFileName: "clip.mp4",
+				OriginalMimeType: ptr("video/mp4"),
+				ExifInfo:         &immich.ExifResponseDto{ExifImageHeight: ptr(float32(720)), FileSizeInByte: ptr(int64(1000))},
+			},
+			expected: "small",
+		},
+		{
+			name:  "unknown size",
+			asset: immich.AssetResponseDto{OriginalFileName: "clip.avi"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := policy.match(tt.asset)
+			got := ""
+			if rule != nil {
+				got = rule.Name
+			}
+			if got != tt.expected {
+				t.Errorf("match() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPolicyMatchNil(t *testing.T) {
+	var policy *Policy
+	if rule := policy.match(immich.AssetResponseDto{}); rule != nil {
+		t.Errorf("Expected no rule without a policy, got %q", rule.Name)
+	}
+}
+
+func TestPolicyRuleApply(t *testing.T) {
+	imageConfig := ImageConfig{Format: JPEG, Quality: 80, MinSimilarity: 0.9}
+	videoConfig := VideoConfig{Container: MP4, Format: HEVC, Quality: 25}
+	rule := PolicyRule{
+		Image: &PolicyImage{Format: JXL, Lossless: ptr(true), MaxDimension: ptr(2048)},
+		Video: &PolicyVideo{Format: AV1, Quality: 30, MaxHeight: ptr(720), MaxFPS: ptr(30.0), AudioTracks: AudioTracksAll, AudioChannels: ptr(2), AudioCopy: ptr(true), DataStreams: DataStreamsKeep, MinBPP: ptr(0.05), HDR: HDRTonemap},
+	}
+	rule.apply(&imageConfig, &videoConfig)
+
+	expectedImage := ImageConfig{Format: JXL, Quality: 80, MinSimilarity: 0.9, Lossless: true, MaxDimension: 2048}
+	if imageConfig != expectedImage {
+		t.Errorf("Expected %+v, got %+v", expectedImage, imageConfig)
+	}
+	expectedVideo := VideoConfig{
+		Container: MP4, Format: AV1, Quality: 30, MaxHeight: 720,
+		MaxFPS: 30, AudioTracks: AudioTracksAll, AudioChannels: 2, AudioCopy: true, DataStreams: DataStreamsKeep,
+		MinBitsPerPixel: 0.05, HDR: HDRTonemap,
+	}
+	if videoConfig != expectedVideo {
+		t.Errorf("Expected %+v, got %+v", expectedVideo, videoConfig)
+	}
+}
+
+func TestPolicyRuleApplyTurnsOff(t *testing.T) {
+	file := writePolicy(t, `
+rules:
+  - image:
+      lossless: false
+      minSsim: 0
+      maxDimension: 0
+    video:
+      audioCopy: false
+      minBpp: 0
+      maxFps: 0
+`)
+	policy, err := LoadPolicy(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	imageConfig := ImageConfig{Format: JXL, Quality: 80, Lossless: true, MinSimilarity: 0.9, MaxDimension: 2048}
+	videoConfig := VideoConfig{Format: HEVC, Quality: 25, AudioCopy: true, MinBitsPerPixel: 0.03, MaxFPS: 30}
+	policy.Rules[0].apply(&imageConfig, &videoConfig)
+
+	expectedImage := ImageConfig{Format: JXL, Quality: 80}
+	if imageConfig != expectedImage {
+		t.Errorf("Expected %+v, got %+v", expectedImage, imageConfig)
+	}
+	expectedVideo := VideoConfig{Format: HEVC, Quality: 25}
+	if videoConfig != expectedVideo {
+		t.Errorf("Expected %+v, got %+v", expectedVideo, videoConfig)
+	}
+}
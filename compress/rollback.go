@@ -0,0 +1,169 @@
+package compress
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"immich-compress/immich"
+
+	"github.com/oapi-codegen/runtime/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// RollbackConfig holds configuration for undoing compression runs
+type RollbackConfig struct {
+	Parallel int
+	Server   string
+	APIKey   string
+	RunID    string
+	Since    time.Time
+	Until    time.Time
+	Retry    immich.Retry
+}
+
+// Rollback restores the originals of compressed assets from the trash and
+// removes the compressed copies. Assets are selected by the run that created
+// them or by the time window in which they were uploaded.
+func Rollback(ctx context.Context, config RollbackConfig) error {
+	if config.RunID == "" && config.Since.IsZero() && config.Until.IsZero() {
+		return fmt.Errorf("rollback needs a run id or a time window")
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(config.Parallel)
+	client, err := immich.NewClientSimple(gCtx, config.Parallel, config.Server, config.APIKey, config.Retry)
+	if err != nil {
+		return err
+	}
+
+	var tagID types.UUID
+	if config.RunID == "" {
+		tagID, err = client.TagCompressedID()
+	} else {
+		tagID, err = client.TagRunFind(config.RunID)
+	}
+	if err != nil {
+		return err
+	}
+	searchOption := immich.SearchAssetsJSONRequestBody{
+		TagIds: &[]types.UUID{tagID},
+	}
+	if !config.Since.IsZero() {
+		searchOption.CreatedAfter = &config.Since
+	}
+	if !config.Until.IsZero() {
+		searchOption.CreatedBefore = &config.Until
+	}
+
+	err = client.StacksLoad()
+	if err != nil {
+		return err
+	}
+
+	// Collect first, trashing the copies while paging would shift the pages
+	var assets []immich.AssetResponseDto
+	for asset := range client.AssetSearch(0, searchOption) {
+		if asset.Err != nil {
+			return asset.Err
+		}
+		if asset.Asset.Stack == nil {
+			asset.Asset.Stack = client.StackOf(asset.Asset.Id)
+		}
+		assets = append(assets, asset.Asset)
+	}
+
+	var counter int32 = 0
+	for _, asset := range assets {
+		g.Go(func() error {
+			select {
+			case <-gCtx.Done():
+				return gCtx.Err()
+			default:
+			}
+
+			err := rollbackFile(client, asset)
+			if err != nil {
+				return err
+			}
+			atomic.AddInt32(&counter, 1)
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored files: %d\n", counter)
+
+	return nil
+}
+
+// rollbackFile restores the original of one compressed asset, gives it back
+// the albums, favorite and tags of the copy and moves the copy to the trash.
+// The motion video of a Live Photo is restored together with its still, a
+// motion video that was kept is the original and stays.
+func rollbackFile(client *immich.ClientSimple, compressed immich.AssetResponseDto) error {
+	original, err := client.AssetFindTrashedOriginal(compressed)
+	if err != nil {
+		return err
+	}
+	uuidOrig, err := immich.UUUIDOfString(original.Id)
+	if err != nil {
+		return err
+	}
+	uuidCompressed, err := immich.UUUIDOfString(compressed.Id)
+	if err != nil {
+		return err
+	}
+	uuidsOrig := []types.UUID{uuidOrig}
+	uuidsCompressed := []types.UUID{uuidCompressed}
+
+	// The original still is still linked to the original motion video
+	if compressed.LivePhotoVideoId != nil {
+		uuidMotion, err := immich.UUUIDOfString(*compressed.LivePhotoVideoId)
+		if err != nil {
+			return err
+		}
+		motion, err := client.AssetInfo(uuidMotion)
+		if err != nil {
+			return err
+		}
+		copied, err := client.AssetIsCompressed(*motion)
+		if err != nil {
+			return err
+		}
+		if copied {
+			motionOrig, err := client.AssetFindTrashedOriginal(*motion)
+			if err != nil {
+				return err
+			}
+			uuidMotionOrig, err := immich.UUUIDOfString(motionOrig.Id)
+			if err != nil {
+				return err
+			}
+			uuidsOrig = append(uuidsOrig, uuidMotionOrig)
+			uuidsCompressed = append(uuidsCompressed, uuidMotion)
+		}
+	}
+
+	err = client.AssetRestore(uuidsOrig)
+	if err != nil {
+		return err
+	}
+	err = client.AssetCopyRelations(compressed, uuidOrig)
+	if err != nil {
+		return err
+	}
+	err = client.AssetDeleteMultiple(uuidsCompressed, false)
+	if err != nil {
+		return fmt.Errorf("can not delete compressed copy: %w", err)
+	}
+
+	fmt.Printf("↺ Restored: %s\n", original.OriginalFileName)
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package compress
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"immich-compress/immich"
+
+	"github.com/google/uuid"
+)
+
+func TestRollbackNeedsSelection(t *testing.T) {
+	config := RollbackConfig{
+		Parallel: 1,
+		Server:   "http://localhost:0",
+		APIKey:   "test-key",
+	}
+
+	err := Rollback(context.Background(), config)
+	if err == nil {
+		t.Fatal("Expected error without run id or time window, got nil")
+	}
+}
+
+// rollbackServer answers a rollback of run with the compressed copy, the
+// device id search with original
+func rollbackServer(t *testing.T, run string, copied, original immich.AssetResponseDto) *fakeServer {
+	t.Helper()
+	server := newFakeServer(t)
+	server.JSON("GET /tags", http.StatusOK, []immich.TagResponseDto{
+		{Id: uuid.NewString(), Name: run, Value: "__immich-compress__/__runs__/" + run},
+	})
+	server.JSON("GET /stacks", http.StatusOK, []immich.StackResponseDto{})
+	server.Handle("POST /search/metadata", func(w http.ResponseWriter, r *http.Request) {
+		var search immich.SearchAssetsJSONRequestBody
+		_ = json.NewDecoder(r.Body).Decode(&search)
+		result := searchResult(copied)
+		if search.DeviceAssetId != nil {
+			result = searchResult(copied, original)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(result)
+	})
+	server.JSON("GET /assets/"+original.Id, http.StatusOK, original)
+	server.JSON("POST /trash/restore/assets", http.StatusOK, immich.TrashResponseDto{Count: 1})
+	server.Handle("PUT /assets/copy", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	server.Handle("DELETE /assets", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return server
+}
+
+// checkRolledBack verifies the original was restored and the copied trashed
+func checkRolledBack(t *testing.T, server *fakeServer, copied, original immich.AssetResponseDto) {
+	t.Helper()
+	restores := server.Received(http.MethodPost, "/trash/restore/assets")
+	if len(restores) != 1 || !strings.Contains(string(restores[0].Body), original.Id) {
+		t.Errorf("Expected the original to be restored, got %+v", restores)
+	}
+	copies := server.Received(http.MethodPut, "/assets/copy")
+	if len(copies) != 1 || !strings.Contains(string(copies[0].Body), `"targetId":"`+original.Id+`"`) {
+		t.Errorf("Expected the relations to be copied to the original, got %+v", copies)
+	}
+	deletes := server.Received(http.MethodDelete, "/assets")
+	if len(deletes) != 1 || !strings.Contains(string(deletes[0].Body), copied.Id) || strings.Contains(string(deletes[0].Body), original.Id) {
+		t.Errorf("Expected only the copied to be trashed, got %+v", deletes)
+	}
+	var body immich.AssetBulkDeleteDto
+	if err := json.Unmarshal(deletes[0].Body, &body); err != nil || (body.Force != nil && *body.Force) {
+		t.Errorf("Expected the copied to go to the trash, got %s", deletes[0].Body)
+	}
+}
+
+func TestRollbackRecordedOriginal(t *testing.T) {
+	// The copied reused a duplicate, its device ids are not the original's
+	original := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.HEIC")
+	original.IsTrashed = true
+	original.DeviceAssetId = "IMG_0001"
+	original.DeviceId = "phone"
+	copied := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.jxl")
+	copied.DeviceAssetId = "duplicate"
+	copied.HasMetadata = true
+
+	server := rollbackServer(t, "20240101-120000", copied, original)
+	server.JSON("GET /assets/"+copied.Id+"/metadata", http.StatusOK, []immich.AssetMetadataResponseDto{
+		{Key: immich.METADATA_COMPRESS, Value: map[string]interface{}{"original": original.Id}},
+	})
+
+	err := Rollback(context.Background(), RollbackConfig{Parallel: 1, Server: server.URL, APIKey: "key", RunID: "20240101-120000"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkRolledBack(t, server, copied, original)
+	if searches := server.Received(http.MethodPost, "/search/metadata"); len(searches) != 1 {
+		t.Errorf("Expected no device id search with a recorded original, got %d searches", len(searches))
+	}
+}
+
+func TestRollbackDeviceIDs(t *testing.T) {
+	// Copies without a record share the device ids with their original
+	original := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.HEIC")
+	original.IsTrashed = true
+	original.DeviceAssetId = "IMG_0001"
+	original.DeviceId = "phone"
+	copied := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.jxl")
+	copied.DeviceAssetId = original.DeviceAssetId
+	copied.DeviceId = original.DeviceId
+
+	server := rollbackServer(t, "20240101-120000", copied, original)
+
+	err := Rollback(context.Background(), RollbackConfig{Parallel: 1, Server: server.URL, APIKey: "key", RunID: "20240101-120000"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkRolledBack(t, server, copied, original)
+}
+
+func TestRollbackLivePhotoKeptMotion(t *testing.T) {
+	// The motion video was skipped, the copy of the still links the original
+	motion := createTestAsset(uuid.NewString(), "VIDEO", "IMG_0001.MOV")
+	motion.DeviceAssetId = "IMG_0001"
+	motion.DeviceId = "phone"
+	original := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.HEIC")
+	original.IsTrashed = true
+	original.LivePhotoVideoId = &motion.Id
+	copied := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.jxl")
+	copied.HasMetadata = true
+	copied.LivePhotoVideoId = &motion.Id
+
+	server := rollbackServer(t, "20240101-120000", copied, original)
+	server.JSON("GET /assets/"+copied.Id+"/metadata", http.StatusOK, []immich.AssetMetadataResponseDto{
+		{Key: immich.METADATA_COMPRESS, Value: map[string]interface{}{"original": original.Id}},
+	})
+	server.JSON("GET /assets/"+motion.Id, http.StatusOK, motion)
+
+	err := Rollback(context.Background(), RollbackConfig{Parallel: 1, Server: server.URL, APIKey: "key", RunID: "20240101-120000"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	checkRolledBack(t, server, copied, original)
+	deletes := server.Received(http.MethodDelete, "/assets")
+	if strings.Contains(string(deletes[0].Body), motion.Id) {
+		t.Errorf("Expected the kept motion video to stay, got %s", deletes[0].Body)
+	}
+	if searches := server.Received(http.MethodPost, "/search/metadata"); len(searches) != 1 {
+		t.Errorf("Expected no search for an original of the kept motion video, got %d searches", len(searches))
+	}
+}
+
+func TestRollbackLivePhotoCompressedMotion(t *testing.T) {
+	motionOrig := createTestAsset(uuid.NewString(), "VIDEO", "IMG_0001.MOV")
+	motionOrig.IsTrashed = true
+	motion := createTestAsset(uuid.NewString(), "VIDEO", "IMG_0001.mkv")
+	motion.HasMetadata = true
+	original := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.HEIC")
+	original.IsTrashed = true
+	copied := createTestAsset(uuid.NewString(), "IMAGE", "IMG_0001.jxl")
+	copied.HasMetadata = true
+	copied.LivePhotoVideoId = &motion.Id
+
+	server := rollbackServer(t, "20240101-120000", copied, original)
+	for id, originalID := range map[string]string{copied.Id: original.Id, motion.Id: motionOrig.Id} {
+		server.JSON("GET /assets/"+id+"/metadata", http.StatusOK, []immich.AssetMetadataResponseDto{
+			{Key: immich.METADATA_COMPRESS, Value: map[string]interface{}{"original": originalID}},
+		})
+	}
+	server.JSON("GET /assets/"+motion.Id, http.StatusOK, motion)
+	server.JSON("GET /assets/"+motionOrig.Id, http.StatusOK, motionOrig)
+
+	err := Rollback(context.Background(), RollbackConfig{Parallel: 1, Server: server.URL, APIKey: "key", RunID: "20240101-120000"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	restores := server.Received(http.MethodPost, "/trash/restore/assets")
+	if len(restores) != 1 || !strings.Contains(string(restores[0].Body), motionOrig.Id) {
+		t.Errorf("Expected the original motion video to be restored, got %+v", restores)
+	}
+	deletes := server.Received(http.MethodDelete, "/assets")
+	if len(deletes) != 1 || !strings.Contains(string(deletes[0].Body), motion.Id) {
+		t.Errorf("Expected the motion video copy to be trashed, got %+v", deletes)
+	}
+}
@@ -0,0 +1,135 @@
+package compress
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"immich-compress/immich"
+
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// Selection narrows down the assets that are compressed. Albums, people and
+// tags are given by name and resolved to ids through the server.
+type Selection struct {
+	Albums           []string
+	People           []string
+	Tags             []string
+	TakenAfter       time.Time
+	TakenBefore      time.Time
+	Make             string
+	Model            string
+	LensModel        string
+	City             string
+	State            string
+	Country          string
+	OriginalFileName string
+	OnlyFavorites    bool
+	ExcludeFavorites bool
+	NotInAlbum       bool
+	Visibility       string
+}
+
+var visibilitiesAvailable = []immich.AssetVisibility{immich.Archive, immich.Hidden, immich.Locked, immich.Timeline}
+
+// empty reports whether the selection narrows down nothing
+func (s Selection) empty() bool {
+	return len(s.Albums) == 0 && len(s.People) == 0 && len(s.Tags) == 0 &&
+		s.TakenAfter.IsZero() && s.TakenBefore.IsZero() &&
+		s.Make == "" && s.Model == "" && s.LensModel == "" &&
+		s.City == "" && s.State == "" && s.Country == "" && s.OriginalFileName == "" &&
+		!s.OnlyFavorites && !s.ExcludeFavorites && !s.NotInAlbum && s.Visibility == ""
+}
+
+// validate checks the selection without contacting the server
+func (s Selection) validate() error {
+	if s.OnlyFavorites && s.ExcludeFavorites {
+		return fmt.Errorf("only favorites and exclude favorites can not be combined")
+	}
+	if s.NotInAlbum && len(s.Albums) > 0 {
+		return fmt.Errorf("not in album and album can not be combined")
+	}
+	if s.Visibility != "" && !slices.Contains(visibilitiesAvailable, immich.AssetVisibility(s.Visibility)) {
+		return fmt.Errorf("unknown visibility: %s", s.Visibility)
+	}
+	if !s.TakenAfter.IsZero() && !s.TakenBefore.IsZero() && !s.TakenAfter.Before(s.TakenBefore) {
+		return fmt.Errorf("taken after must be before taken before")
+	}
+	return nil
+}
+
+// apply sets the filters of the selection on the search
+func (s Selection) apply(client *immich.ClientSimple, search *immich.SearchAssetsJSONRequestBody) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	if len(s.Albums) > 0 {
+		ids, err := resolveNames(s.Albums, client.AlbumFind)
+		if err != nil {
+			return err
+		}
+		search.AlbumIds = &ids
+	}
+	if len(s.People) > 0 {
+		ids, err := resolveNames(s.People, client.PersonFind)
+		if err != nil {
+			return err
+		}
+		search.PersonIds = &ids
+	}
+	if len(s.Tags) > 0 {
+		ids, err := resolveNames(s.Tags, client.TagFind)
+		if err != nil {
+			return err
+		}
+		search.TagIds = &ids
+	}
+
+	if !s.TakenAfter.IsZero() {
+		search.TakenAfter = &s.TakenAfter
+	}
+	if !s.TakenBefore.IsZero() {
+		search.TakenBefore = &s.TakenBefore
+	}
+	search.Make = optionalString(s.Make)
+	search.Model = optionalString(s.Model)
+	search.LensModel = optionalString(s.LensModel)
+	search.City = optionalString(s.City)
+	search.State = optionalString(s.State)
+	search.Country = optionalString(s.Country)
+	search.OriginalFileName = optionalString(s.OriginalFileName)
+	if s.OnlyFavorites || s.ExcludeFavorites {
+		isFavorite := s.OnlyFavorites
+		search.IsFavorite = &isFavorite
+	}
+	if s.NotInAlbum {
+		search.IsNotInAlbum = &s.NotInAlbum
+	}
+	if s.Visibility != "" {
+		visibility := immich.AssetVisibility(s.Visibility)
+		search.Visibility = &visibility
+	}
+
+	return nil
+}
+
+func resolveNames(names []string, find func(string) (types.UUID, error)) ([]types.UUID, error) {
+	ids := make([]types.UUID, 0, len(names))
+	for _, name := range names {
+		id, err := find(name)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,98 @@
+package compress
+
+import (
+	"testing"
+	"time"
+
+	"immich-compress/immich"
+)
+
+func TestSelectionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection Selection
+		wantErr   bool
+	}{
+		{name: "empty", selection: Selection{}},
+		{name: "favorites both ways", selection: Selection{OnlyFavorites: true, ExcludeFavorites: true}, wantErr: true},
+		{name: "album and not in album", selection: Selection{Albums: []string{"Trip"}, NotInAlbum: true}, wantErr: true},
+		{name: "known visibility", selection: Selection{Visibility: "archive"}},
+		{name: "unknown visibility", selection: Selection{Visibility: "public"}, wantErr: true},
+		{
+			name: "taken window reversed",
+			selection: Selection{
+				TakenAfter:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				TakenBefore: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.selection.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelectionEmpty(t *testing.T) {
+	if !(Selection{}).empty() {
+		t.Error("Expected the zero selection to be empty")
+	}
+	for _, selection := range []Selection{
+		{Albums: []string{"Trip"}},
+		{TakenBefore: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{City: "Berlin"},
+		{NotInAlbum: true},
+		{Visibility: "archive"},
+	} {
+		if selection.empty() {
+			t.Errorf("Expected %+v not to be empty", selection)
+		}
+	}
+}
+
+func TestSelectionApply(t *testing.T) {
+	takenAfter := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	selection := Selection{
+		TakenAfter:       takenAfter,
+		Make:             "Apple",
+		City:             "Berlin",
+		ExcludeFavorites: true,
+		Visibility:       "archive",
+	}
+
+	// Without names to resolve the client is not needed
+	var search immich.SearchAssetsJSONRequestBody
+	if err := selection.apply(nil, &search); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if search.TakenAfter == nil || !search.TakenAfter.Equal(takenAfter) {
+		t.Errorf("Expected takenAfter %v, got %v", takenAfter, search.TakenAfter)
+	}
+	if search.TakenBefore != nil {
+		t.Errorf("Expected no takenBefore, got %v", search.TakenBefore)
+	}
+	if search.Make == nil || *search.Make != "Apple" {
+		t.Errorf("Expected make Apple, got %v", search.Make)
+	}
+	if search.Model != nil {
+		t.Errorf("Expected no model, got %v", *search.Model)
+	}
+	if search.City == nil || *search.City != "Berlin" {
+		t.Errorf("Expected city Berlin, got %v", search.City)
+	}
+	if search.IsFavorite == nil || *search.IsFavorite {
+		t.Errorf("Expected favorites to be excluded, got %v", search.IsFavorite)
+	}
+	if search.Visibility == nil || *search.Visibility != immich.Archive {
+		t.Errorf("Expected archive visibility, got %v", search.Visibility)
+	}
+	if search.AlbumIds != nil || search.PersonIds != nil || search.TagIds != nil {
+		t.Error("Expected no album, person or tag filter")
+	}
+}
@@ -0,0 +1,218 @@
+package compress
+
+import (
+	"fmt"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+const (
+	// ssimSigma is the gaussian window used for the local statistics
+	ssimSigma = 1.5
+	// ssimC1 and ssimC2 stabilise the division for an 8 bit dynamic range
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// similarity returns the mean structural similarity (SSIM) of the luminance
+// of two images, 1 means identical. The original is scaled to the size of
+// the compressed image when they differ.
+func similarity(original, compressed *vips.Image) (float64, error) {
+	x, err := ssimPrepare(original, compressed.Width(), compressed.Height())
+	if err != nil {
+		return 0, err
+	}
+	defer x.Close()
+	y, err := ssimPrepare(compressed, compressed.Width(), compressed.Height())
+	if err != nil {
+		return 0, err
+	}
+	defer y.Close()
+
+	// Local means and (co)variances over the gaussian window
+	muX, err := ssimBlur(x, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer muX.Close()
+	muY, err := ssimBlur(y, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer muY.Close()
+	sigmaX, err := ssimBlur(x, x)
+	if err != nil {
+		return 0, err
+	}
+	defer sigmaX.Close()
+	sigmaY, err := ssimBlur(y, y)
+	if err != nil {
+		return 0, err
+	}
+	defer sigmaY.Close()
+	sigmaXY, err := ssimBlur(x, y)
+	if err != nil {
+		return 0, err
+	}
+	defer sigmaXY.Close()
+
+	muXX, err := ssimProduct(muX, muX)
+	if err != nil {
+		return 0, err
+	}
+	defer muXX.Close()
+	muYY, err := ssimProduct(muY, muY)
+	if err != nil {
+		return 0, err
+	}
+	defer muYY.Close()
+	muXY, err := ssimProduct(muX, muY)
+	if err != nil {
+		return 0, err
+	}
+	defer muXY.Close()
+
+	// sigma = E[ab] - E[a]E[b]
+	if err := sigmaX.Subtract(muXX); err != nil {
+		return 0, err
+	}
+	if err := sigmaY.Subtract(muYY); err != nil {
+		return 0, err
+	}
+	if err := sigmaXY.Subtract(muXY); err != nil {
+		return 0, err
+	}
+
+	// numerator = (2 muX muY + C1) * (2 sigmaXY + C2)
+	numerator, err := muXY.Copy(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer numerator.Close()
+	if err := numerator.Linear([]float64{2}, []float64{ssimC1}, nil); err != nil {
+		return 0, err
+	}
+	if err := sigmaXY.Linear([]float64{2}, []float64{ssimC2}, nil); err != nil {
+		return 0, err
+	}
+	if err := numerator.Multiply(sigmaXY); err != nil {
+		return 0, err
+	}
+
+	// denominator = (muX^2 + muY^2 + C1) * (sigmaX + sigmaY + C2)
+	denominator, err := muXX.Copy(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer denominator.Close()
+	if err := denominator.Add(muYY); err != nil {
+		return 0, err
+	}
+	if err := denominator.Linear([]float64{1}, []float64{ssimC1}, nil); err != nil {
+		return 0, err
+	}
+	if err := sigmaX.Add(sigmaY); err != nil {
+		return 0, err
+	}
+	if err := sigmaX.Linear([]float64{1}, []float64{ssimC2}, nil); err != nil {
+		return 0, err
+	}
+	if err := denominator.Multiply(sigmaX); err != nil {
+		return 0, err
+	}
+
+	if err := numerator.Divide(denominator); err != nil {
+		return 0, err
+	}
+	score, err := numerator.Avg()
+	if err != nil {
+		return 0, fmt.Errorf("failed to average similarity map: %w", err)
+	}
+
+	return score, nil
+}
+
+// similarityOfFile decodes an encoded image and compares it with the
+// original
+func similarityOfFile(original *vips.Image, file string) (float64, error) {
+	compressed, err := vips.NewImageFromFile(file, vips.DefaultLoadOptions())
+	if err != nil {
+		return 0, fmt.Errorf("failed to load compressed image: %w", err)
+	}
+	defer compressed.Close()
+
+	score, err := similarity(original, compressed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute similarity: %w", err)
+	}
+	return score, nil
+}
+
+// ssimPrepare returns the 8 bit luminance of an image as float, scaled to
+// width x height
+func ssimPrepare(image *vips.Image, width, height int) (*vips.Image, error) {
+	prepared, err := image.Copy(nil)
+	if err != nil {
+		return nil, err
+	}
+	err = ssimPrepareInPlace(prepared, width, height)
+	if err != nil {
+		prepared.Close()
+		return nil, fmt.Errorf("failed to prepare image for similarity: %w", err)
+	}
+	return prepared, nil
+}
+
+func ssimPrepareInPlace(image *vips.Image, width, height int) error {
+	if image.Width() != width || image.Height() != height {
+		options := vips.DefaultResizeOptions()
+		options.Vscale = float64(height) / float64(image.Height())
+		if err := image.Resize(float64(width)/float64(image.Width()), options); err != nil {
+			return err
+		}
+	}
+	if err := image.Colourspace(vips.InterpretationBW, nil); err != nil {
+		return err
+	}
+	// Drop alpha, only the luminance is compared
+	if err := image.ExtractBand(0, nil); err != nil {
+		return err
+	}
+	if err := image.Cast(vips.BandFormatUchar, &vips.CastOptions{Shift: true}); err != nil {
+		return err
+	}
+	return image.Cast(vips.BandFormatFloat, nil)
+}
+
+// ssimBlur returns the gaussian weighted local mean of a, or of a*b when b
+// is set
+func ssimBlur(a, b *vips.Image) (*vips.Image, error) {
+	var blurred *vips.Image
+	var err error
+	if b == nil {
+		blurred, err = a.Copy(nil)
+	} else {
+		blurred, err = ssimProduct(a, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := blurred.Gaussblur(ssimSigma, nil); err != nil {
+		blurred.Close()
+		return nil, err
+	}
+	return blurred, nil
+}
+
+// ssimProduct returns the pixel wise product of a and b
+func ssimProduct(a, b *vips.Image) (*vips.Image, error) {
+	product, err := a.Copy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := product.Multiply(b); err != nil {
+		product.Close()
+		return nil, err
+	}
+	return product, nil
+}
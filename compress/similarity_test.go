@@ -0,0 +1,80 @@
+package compress
+
+import (
+	"testing"
+
+	"github.com/cshum/vipsgen/vips"
+)
+
+func newNoiseImage(t *testing.T, seed int) *vips.Image {
+	t.Helper()
+	options := &vips.GaussnoiseOptions{Sigma: 30, Mean: 128, Seed: seed}
+	image, err := vips.NewGaussnoise(64, 48, options)
+	if err != nil {
+		t.Fatalf("Failed to create noise image: %v", err)
+	}
+	return image
+}
+
+func TestSimilarityIdentical(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skip("vips not available, skipping integration test")
+		}
+	}()
+
+	image := newNoiseImage(t, 1)
+	defer image.Close()
+
+	score, err := similarity(image, image)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score < 0.999 {
+		t.Errorf("Expected identical images to score ~1, got %v", score)
+	}
+}
+
+func TestSimilarityDifferent(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skip("vips not available, skipping integration test")
+		}
+	}()
+
+	original := newNoiseImage(t, 1)
+	defer original.Close()
+	other := newNoiseImage(t, 2)
+	defer other.Close()
+
+	score, err := similarity(original, other)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score > 0.5 {
+		t.Errorf("Expected unrelated noise to score low, got %v", score)
+	}
+}
+
+func TestSimilarityScalesOriginal(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skip("vips not available, skipping integration test")
+		}
+	}()
+
+	original := newNoiseImage(t, 1)
+	defer original.Close()
+	smaller, err := original.Copy(nil)
+	if err != nil {
+		t.Fatalf("Failed to copy image: %v", err)
+	}
+	defer smaller.Close()
+	if err := smaller.Resize(0.5, nil); err != nil {
+		t.Fatalf("Failed to resize image: %v", err)
+	}
+
+	if _, err := similarity(original, smaller); err != nil {
+		t.Errorf("Expected images of different size to be comparable, got %v", err)
+	}
+}
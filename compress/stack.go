@@ -0,0 +1,27 @@
+package compress
+
+import "immich-compress/immich"
+
+// StackPolicy selects which members of a stack are compressed
+type StackPolicy string
+
+const (
+	StackAll        StackPolicy = "all"
+	StackPrimary    StackPolicy = "primary"
+	StackNonPrimary StackPolicy = "non-primary"
+)
+
+var StackPoliciesAvailable = []StackPolicy{StackAll, StackPrimary, StackNonPrimary}
+
+// allows reports whether the policy compresses the asset. Assets outside
+// of a stack are always compressed.
+func (p StackPolicy) allows(asset immich.AssetResponseDto) bool {
+	if asset.Stack == nil || p == StackAll {
+		return true
+	}
+	primary := asset.Stack.PrimaryAssetId == asset.Id
+	if p == StackPrimary {
+		return primary
+	}
+	return !primary
+}
@@ -0,0 +1,45 @@
+package compress
+
+import (
+	"context"
+	"testing"
+
+	"immich-compress/immich"
+)
+
+func TestStackPolicyAllows(t *testing.T) {
+	stack := &immich.AssetStackResponseDto{Id: "stack", PrimaryAssetId: "primary", AssetCount: 2}
+	primary := immich.AssetResponseDto{Id: "primary", Stack: stack}
+	member := immich.AssetResponseDto{Id: "member", Stack: stack}
+	single := immich.AssetResponseDto{Id: "single"}
+
+	tests := []struct {
+		policy   StackPolicy
+		asset    immich.AssetResponseDto
+		expected bool
+	}{
+		{StackAll, primary, true},
+		{StackAll, member, true},
+		{StackAll, single, true},
+		{StackPrimary, primary, true},
+		{StackPrimary, member, false},
+		{StackPrimary, single, true},
+		{StackNonPrimary, primary, false},
+		{StackNonPrimary, member, true},
+		{StackNonPrimary, single, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.allows(tt.asset); got != tt.expected {
+			t.Errorf("%s.allows(%s) = %v, expected %v", tt.policy, tt.asset.Id, got, tt.expected)
+		}
+	}
+}
+
+func TestCompressingUnknownStackPolicy(t *testing.T) {
+	// Rejected before the server is contacted
+	err := Compressing(context.Background(), Config{StackPolicy: StackPolicy("some")})
+	if err == nil {
+		t.Error("Expected error for unknown stack policy, got nil")
+	}
+}
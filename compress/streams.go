@@ -0,0 +1,285 @@
+package compress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// AudioTracks selects the audio tracks that are kept
+type AudioTracks string
+
+const (
+	AudioTracksAll   AudioTracks = "all"
+	AudioTracksFirst AudioTracks = "first"
+	AudioTracksNone  AudioTracks = "none"
+)
+
+var AudioTracksAvailable = []AudioTracks{AudioTracksAll, AudioTracksFirst, AudioTracksNone}
+
+// DataStreams selects what happens to data streams like GoPro telemetry or
+// timecode tracks
+type DataStreams string
+
+const (
+	DataStreamsDrop DataStreams = "drop"
+	DataStreamsKeep DataStreams = "keep"
+)
+
+var DataStreamsAvailable = []DataStreams{DataStreamsDrop, DataStreamsKeep}
+
+// audioCopyMaxBitRate is the highest bit rate of an AAC or Opus track that
+// is copied by AudioCopy, re-encoding at 128k would not save much below it
+const audioCopyMaxBitRate = 160_000
+
+// hdrTransfers are the ffprobe color_transfer values of HDR video
+var hdrTransfers = []string{"smpte2084", "arib-std-b67"}
+
+// pixFmtDepthRegexp finds the bit depth in yuv420p10le (second group) and
+// in the semi planar p010le (first group)
+var pixFmtDepthRegexp = regexp.MustCompile(`^p0(\d+)|p(\d+)(?:le|be)?$`)
+
+// efficientAudioCodecs are the codecs AudioCopy keeps as they are
+var efficientAudioCodecs = []string{"aac", "opus"}
+
+// mediaStreams is what ffprobe found in the input of an encode
+type mediaStreams struct {
+	video videoStream
+	audio []audioStream
+	data  int
+}
+
+// videoStream describes the first video stream, unknown values are 0
+type videoStream struct {
+	codec         string
+	width, height int
+	fps           float64
+	// bitRate falls back to the rate of the whole file when the container
+	// has none per stream
+	bitRate  int64
+	bitDepth int
+	// hdr is set for PQ (HDR10, Dolby Vision) and HLG transfer
+	hdr   bool
+	color colorInfo
+	// mastering and contentLight are the HDR10 static metadata, nil when
+	// the stream has none
+	mastering    *masteringDisplay
+	contentLight *contentLight
+}
+
+type audioStream struct {
+	codec    string
+	bitRate  int64
+	channels int
+}
+
+// probeStreams inspects the streams of a video with ffprobe
+func probeStreams(ctx context.Context, file string) (mediaStreams, error) {
+	output, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_entries", "stream=codec_type,codec_name,bit_rate,channels,avg_frame_rate,width,height,pix_fmt,color_transfer,color_primaries,color_space,color_range:"+
+			"stream_side_data=side_data_type,red_x,red_y,green_x,green_y,blue_x,blue_y,white_point_x,white_point_y,min_luminance,max_luminance,max_content,max_average:"+
+			"format=bit_rate",
+		file,
+	).Output()
+	if err != nil {
+		return mediaStreams{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	return parseStreams(output)
+}
+
+func parseStreams(output []byte) (mediaStreams, error) {
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return mediaStreams{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var streams mediaStreams
+	seenVideo := false
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if seenVideo {
+				continue
+			}
+			seenVideo = true
+			bitRate, err := strconv.ParseInt(stream.BitRate, 10, 64)
+			if err != nil {
+				bitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+			}
+			streams.video = videoStream{
+				codec:    stream.CodecName,
+				width:    stream.Width,
+				height:   stream.Height,
+				fps:      parseRational(stream.AvgFrameRate),
+				bitRate:  bitRate,
+				bitDepth: pixelBitDepth(stream.PixFmt),
+				hdr:      slices.Contains(hdrTransfers, stream.ColorTransfer),
+				color: colorInfo{
+					primaries:  stream.ColorPrimaries,
+					transfer:   stream.ColorTransfer,
+					space:      stream.ColorSpace,
+					colorRange: stream.ColorRange,
+				},
+			}
+			streams.video.mastering, streams.video.contentLight = parseHDRSideData(stream.SideDataList)
+		case "audio":
+			// bit_rate is missing in some containers, 0 then
+			bitRate, _ := strconv.ParseInt(stream.BitRate, 10, 64)
+			streams.audio = append(streams.audio, audioStream{
+				codec:    stream.CodecName,
+				bitRate:  bitRate,
+				channels: stream.Channels,
+			})
+		case "data":
+			streams.data++
+		}
+	}
+	return streams, nil
+}
+
+// parseRational reads a rational like the frame rate 30000/1001, 0 when it
+// is unknown
+func parseRational(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// pixelBitDepth returns the bits per component of an ffmpeg pixel format
+// like yuv420p10le or p010le, 0 when it is unknown
+func pixelBitDepth(pixFmt string) int {
+	if pixFmt == "" {
+		return 0
+	}
+	if match := pixFmtDepthRegexp.FindStringSubmatch(pixFmt); match != nil {
+		depth, _ := strconv.Atoi(match[1] + match[2])
+		return depth
+	}
+	return 8
+}
+
+// bitsPerPixel returns the bits spent per pixel and frame, 0 when the bit
+// rate, size or frame rate is unknown
+func (v videoStream) bitsPerPixel() float64 {
+	if v.bitRate <= 0 || v.width <= 0 || v.height <= 0 || v.fps <= 0 {
+		return 0
+	}
+	return float64(v.bitRate) / (float64(v.width*v.height) * v.fps)
+}
+
+// String describes the stream for the log, like
+// "hevc 1920x1080 10 bit HDR 59.94 fps 12.3 Mbit/s (0.099 bpp)"
+func (v videoStream) String() string {
+	parts := []string{v.codec, fmt.Sprintf("%dx%d", v.width, v.height)}
+	if v.bitDepth > 0 {
+		parts = append(parts, fmt.Sprintf("%d bit", v.bitDepth))
+	}
+	if v.hdr {
+		parts = append(parts, "HDR")
+	}
+	if v.fps > 0 {
+		parts = append(parts, strconv.FormatFloat(v.fps, 'f', 2, 64)+" fps")
+	}
+	if v.bitRate > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f Mbit/s", float64(v.bitRate)/1_000_000))
+	}
+	if bpp := v.bitsPerPixel(); bpp > 0 {
+		parts = append(parts, fmt.Sprintf("(%.3f bpp)", bpp))
+	}
+	return strings.Join(parts, " ")
+}
+
+// checkEfficient skips videos a re-encode would hardly shrink: those already
+// in the target codec and those below MinBitsPerPixel. Videos whose frame
+// rate or resolution gets capped are always encoded.
+func (c *VideoConfig) checkEfficient(source mediaStreams) error {
+	if c.reduces(source) {
+		return nil
+	}
+	video := source.video
+	if video.codec == string(c.Format) {
+		return skipAsset("already %s", video.codec)
+	}
+	if bpp := video.bitsPerPixel(); c.MinBitsPerPixel > 0 && bpp > 0 && bpp < c.MinBitsPerPixel {
+		return skipAsset("%.3f bits per pixel is below %.3f", bpp, c.MinBitsPerPixel)
+	}
+	return nil
+}
+
+// reduces reports whether the frame rate or resolution cap applies to the
+// source
+func (c *VideoConfig) reduces(source mediaStreams) bool {
+	if c.fpsFilter(source) != "" {
+		return true
+	}
+	return c.MaxHeight > 0 && min(source.video.width, source.video.height) > c.MaxHeight
+}
+
+// fpsFilter returns the ffmpeg filter that caps the frame rate, empty when
+// the source is not faster than MaxFPS
+func (c *VideoConfig) fpsFilter(source mediaStreams) string {
+	// A small margin keeps 30000/1001 sources from being touched by a 30 cap
+	if c.MaxFPS <= 0 || source.video.fps <= c.MaxFPS*1.01 {
+		return ""
+	}
+	return "fps=" + strconv.FormatFloat(c.MaxFPS, 'f', -1, 64)
+}
+
+// streamArgs returns the ffmpeg arguments that select the streams of the
+// output and decide per audio track whether it is copied, downmixed or
+// re-encoded with the codec of codecArgs
+func (c *VideoConfig) streamArgs(source mediaStreams) ([]string, error) {
+	args := []string{"-map", "0:v:0"}
+
+	var tracks []audioStream
+	switch c.AudioTracks {
+	case AudioTracksAll:
+		tracks = source.audio
+	case AudioTracksNone:
+	default:
+		if len(source.audio) > 0 {
+			tracks = source.audio[:1]
+		}
+	}
+	for i, track := range tracks {
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", i))
+		downmix := c.AudioChannels > 0 && track.channels > c.AudioChannels
+		switch {
+		case downmix:
+			args = append(args, fmt.Sprintf("-ac:a:%d", i), strconv.Itoa(c.AudioChannels))
+		case c.AudioCopy && track.efficient():
+			args = append(args, fmt.Sprintf("-c:a:%d", i), "copy")
+		}
+	}
+
+	if c.DataStreams == DataStreamsKeep && source.data > 0 {
+		if c.Container == MKV {
+			return nil, skipAsset("%s can not store data streams", c.Container)
+		}
+		args = append(args, "-map", "0:d", "-c:d", "copy")
+	}
+	return args, nil
+}
+
+// efficient reports whether the track is already compressed well enough to
+// be copied
+func (a audioStream) efficient() bool {
+	return slices.Contains(efficientAudioCodecs, a.codec) && a.bitRate > 0 && a.bitRate <= audioCopyMaxBitRate
+}
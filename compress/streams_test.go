@@ -0,0 +1,292 @@
+package compress
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+const ffprobeStreamsGoPro = `{
+	"streams": [
+		{"codec_type": "video", "codec_name": "hevc", "avg_frame_rate": "60000/1001", "width": 3840, "height": 2160, "pix_fmt": "yuv420p10le", "color_transfer": "arib-std-b67"},
+		{"codec_type": "audio", "codec_name": "aac", "bit_rate": "189375", "channels": 2},
+		{"codec_type": "data", "codec_name": "none"},
+		{"codec_type": "data", "codec_name": "bin_data"}
+	],
+	"format": {"bit_rate": "60000000"}
+}`
+
+func TestParseStreams(t *testing.T) {
+	streams, err := parseStreams([]byte(ffprobeStreamsGoPro))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if streams.video.fps < 59.9 || streams.video.fps > 60 {
+		t.Errorf("Expected about 59.94 fps, got %f", streams.video.fps)
+	}
+	if streams.video.codec != "hevc" || streams.video.width != 3840 || streams.video.height != 2160 {
+		t.Errorf("Unexpected video stream %+v", streams.video)
+	}
+	if streams.video.bitDepth != 10 || !streams.video.hdr {
+		t.Errorf("Expected 10 bit HDR, got %+v", streams.video)
+	}
+	// Without a stream bit rate the rate of the file is used
+	if streams.video.bitRate != 60000000 {
+		t.Errorf("Expected the format bit rate, got %d", streams.video.bitRate)
+	}
+	expected := []audioStream{{codec: "aac", bitRate: 189375, channels: 2}}
+	if !slices.Equal(streams.audio, expected) {
+		t.Errorf("Expected audio %+v, got %+v", expected, streams.audio)
+	}
+	if streams.data != 2 {
+		t.Errorf("Expected 2 data streams, got %d", streams.data)
+	}
+}
+
+func TestParseStreamsInvalid(t *testing.T) {
+	if _, err := parseStreams([]byte("not json")); err == nil {
+		t.Error("Expected an error for invalid output")
+	}
+}
+
+func TestParseRational(t *testing.T) {
+	tests := []struct {
+		rate     string
+		expected float64
+	}{
+		{"30/1", 30},
+		{"25", 25},
+		{"0/0", 0},
+		{"", 0},
+		{"abc/1", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRational(tt.rate); got != tt.expected {
+			t.Errorf("parseRational(%q) = %f, expected %f", tt.rate, got, tt.expected)
+		}
+	}
+}
+
+func TestPixelBitDepth(t *testing.T) {
+	tests := []struct {
+		pixFmt   string
+		expected int
+	}{
+		{"yuv420p", 8},
+		{"yuvj420p", 8},
+		{"yuv420p10le", 10},
+		{"yuv444p12be", 12},
+		{"gbrp10le", 10},
+		{"p010le", 10},
+		{"nv12", 8},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := pixelBitDepth(tt.pixFmt); got != tt.expected {
+			t.Errorf("pixelBitDepth(%q) = %d, expected %d", tt.pixFmt, got, tt.expected)
+		}
+	}
+}
+
+func TestVideoStreamBitsPerPixel(t *testing.T) {
+	video := videoStream{width: 1920, height: 1080, fps: 30, bitRate: 6_220_800}
+	if got := video.bitsPerPixel(); got < 0.0999 || got > 0.1001 {
+		t.Errorf("Expected 0.1 bpp, got %f", got)
+	}
+	video.fps = 0
+	if got := video.bitsPerPixel(); got != 0 {
+		t.Errorf("Expected 0 without a frame rate, got %f", got)
+	}
+}
+
+func TestVideoStreamString(t *testing.T) {
+	video := videoStream{codec: "hevc", width: 1920, height: 1080, fps: 30, bitRate: 6_220_800, bitDepth: 10, hdr: true}
+	expected := "hevc 1920x1080 10 bit HDR 30.00 fps 6.2 Mbit/s (0.100 bpp)"
+	if got := video.String(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestVideoConfigCheckEfficient(t *testing.T) {
+	h264 := videoStream{codec: "h264", width: 1920, height: 1080, fps: 30, bitRate: 12_000_000}
+	lean := videoStream{codec: "h264", width: 1920, height: 1080, fps: 30, bitRate: 1_000_000}
+	av1 := videoStream{codec: "av1", width: 1920, height: 1080, fps: 60, bitRate: 4_000_000}
+
+	tests := []struct {
+		name   string
+		config VideoConfig
+		video  videoStream
+		skip   bool
+	}{
+		{name: "plenty of bits", config: VideoConfig{Format: AV1, MinBitsPerPixel: 0.03}, video: h264},
+		{name: "below threshold", config: VideoConfig{Format: AV1, MinBitsPerPixel: 0.03}, video: lean, skip: true},
+		{name: "threshold disabled", config: VideoConfig{Format: AV1}, video: lean},
+		{name: "target codec", config: VideoConfig{Format: AV1}, video: av1, skip: true},
+		{name: "target codec with fps cap", config: VideoConfig{Format: AV1, MaxFPS: 30}, video: av1},
+		{name: "target codec with height cap", config: VideoConfig{Format: AV1, MaxHeight: 720}, video: av1},
+		{name: "target codec under height cap", config: VideoConfig{Format: AV1, MaxHeight: 1080}, video: av1, skip: true},
+		{name: "unknown bit rate", config: VideoConfig{Format: AV1, MinBitsPerPixel: 0.03}, video: videoStream{codec: "h264"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.checkEfficient(mediaStreams{video: tt.video})
+			var skip *skipError
+			if errors.As(err, &skip) != tt.skip {
+				t.Errorf("checkEfficient() = %v, expected skip %v", err, tt.skip)
+			}
+			if err != nil && !tt.skip {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestVideoConfigFPSFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxFPS   float64
+		fps      float64
+		expected string
+	}{
+		{name: "disabled", maxFPS: 0, fps: 60, expected: ""},
+		{name: "faster source", maxFPS: 30, fps: 59.94, expected: "fps=30"},
+		{name: "ntsc source", maxFPS: 30, fps: 29.97, expected: ""},
+		{name: "slower source", maxFPS: 30, fps: 24, expected: ""},
+		{name: "unknown rate", maxFPS: 30, fps: 0, expected: ""},
+		{name: "fractional cap", maxFPS: 23.976, fps: 50, expected: "fps=23.976"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := VideoConfig{MaxFPS: tt.maxFPS}
+			if got := config.fpsFilter(mediaStreams{video: videoStream{fps: tt.fps}}); got != tt.expected {
+				t.Errorf("fpsFilter() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVideoConfigStreamArgs(t *testing.T) {
+	source := mediaStreams{
+		video: videoStream{fps: 30},
+		audio: []audioStream{
+			{codec: "aac", bitRate: 128000, channels: 2},
+			{codec: "pcm_s16le", bitRate: 1536000, channels: 6},
+		},
+		data: 1,
+	}
+
+	tests := []struct {
+		name     string
+		config   VideoConfig
+		expected []string
+	}{
+		{
+			name:     "default keeps the first track",
+			config:   VideoConfig{Container: MKV},
+			expected: []string{"-map", "0:v:0", "-map", "0:a:0"},
+		},
+		{
+			name:     "all tracks with copy and downmix",
+			config:   VideoConfig{Container: MKV, AudioTracks: AudioTracksAll, AudioCopy: true, AudioChannels: 2},
+			expected: []string{"-map", "0:v:0", "-map", "0:a:0", "-c:a:0", "copy", "-map", "0:a:1", "-ac:a:1", "2"},
+		},
+		{
+			name:     "no audio",
+			config:   VideoConfig{Container: MKV, AudioTracks: AudioTracksNone},
+			expected: []string{"-map", "0:v:0"},
+		},
+		{
+			name:     "keep data in mp4",
+			config:   VideoConfig{Container: MP4, AudioTracks: AudioTracksNone, DataStreams: DataStreamsKeep},
+			expected: []string{"-map", "0:v:0", "-map", "0:d", "-c:d", "copy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := tt.config.streamArgs(source)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !slices.Equal(args, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, args)
+			}
+		})
+	}
+}
+
+// TestVideoConfigEncodeArgsAudioCopy verifies a copied track is not
+// re-encoded by the -c:a of the codec, which would apply when it came last
+func TestVideoConfigEncodeArgsAudioCopy(t *testing.T) {
+	source := mediaStreams{
+		video: videoStream{fps: 30},
+		audio: []audioStream{{codec: "aac", bitRate: 128000, channels: 2}},
+	}
+	config := VideoConfig{Container: MKV, Format: AV1, AudioCopy: true}
+	codecArgs, err := config.codecArgs(source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	streamArgs, err := config.streamArgs(source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	args := config.encodeArgs("in.mp4", "out.mkv", codecArgs, streamArgs, 30)
+	expected := []string{
+		"-i", "in.mp4",
+		"-c:v", "libsvtav1", "-pix_fmt", "yuv420p10le", "-preset", "5", "-c:a", "libopus", "-b:a", "128k",
+		"-map", "0:v:0", "-map", "0:a:0", "-c:a:0", "copy",
+		"-map_metadata", "0", "-map_metadata:s:v", "0:s:v", "-map_metadata:s:a", "0:s:a", "-map_chapters", "0",
+		"-crf", "30", "out.mkv",
+	}
+	if !slices.Equal(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+	last := ""
+	for i, arg := range args[:len(args)-1] {
+		if arg == "-c:a" || arg == "-c:a:0" {
+			last = args[i+1]
+		}
+	}
+	if last != "copy" {
+		t.Errorf("Expected the copy of track 0 to be the last audio codec option, got %s", last)
+	}
+}
+
+func TestVideoConfigStreamArgsDataInMKV(t *testing.T) {
+	config := VideoConfig{Container: MKV, DataStreams: DataStreamsKeep}
+	_, err := config.streamArgs(mediaStreams{data: 1})
+	var skip *skipError
+	if !errors.As(err, &skip) {
+		t.Errorf("Expected a skip error, got %v", err)
+	}
+
+	// Without data streams there is nothing to keep
+	if _, err := config.streamArgs(mediaStreams{}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestAudioStreamEfficient(t *testing.T) {
+	tests := []struct {
+		stream   audioStream
+		expected bool
+	}{
+		{audioStream{codec: "opus", bitRate: 96000}, true},
+		{audioStream{codec: "aac", bitRate: 160000}, true},
+		{audioStream{codec: "aac", bitRate: 256000}, false},
+		{audioStream{codec: "aac"}, false},
+		{audioStream{codec: "pcm_s16le", bitRate: 64000}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.stream.efficient(); got != tt.expected {
+			t.Errorf("%+v.efficient() = %v, expected %v", tt.stream, got, tt.expected)
+		}
+	}
+}
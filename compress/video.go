@@ -3,11 +3,11 @@ package compress
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"immich-compress/immich"
 
@@ -18,6 +18,32 @@ type VideoConfig struct {
 	Container VideoContainer
 	Format    VideoFormat
 	Quality   int
+	// TargetVMAF picks the highest CRF between MinCRF and MaxCRF whose
+	// sample encodes still reach this VMAF score instead of using Quality
+	// (0-100, 0 disables the search)
+	TargetVMAF float64
+	MinCRF     int
+	MaxCRF     int
+	// MaxHeight downscales videos whose shorter side is larger, so portrait
+	// videos get the same cap. 0 keeps the resolution.
+	MaxHeight int
+	// MaxFPS lowers the frame rate of faster videos, 0 keeps it
+	MaxFPS      float64
+	AudioTracks AudioTracks
+	// AudioChannels downmixes tracks with more channels, 0 keeps them
+	AudioChannels int
+	// AudioCopy copies AAC and Opus tracks of a low bit rate instead of
+	// re-encoding them
+	AudioCopy   bool
+	DataStreams DataStreams
+	// MinBitsPerPixel skips videos that spend fewer bits per pixel and
+	// frame, they are efficient already. 0 disables the check.
+	MinBitsPerPixel float64
+	// HDR decides about HDR videos when Format can not carry HDR
+	HDR HDRMode
+	// Threads is the number of threads of one encode, 0 lets ffmpeg use
+	// every CPU
+	Threads int
 }
 
 type VideoContainer string
@@ -39,42 +65,112 @@ const (
 
 var VideoFormatsAvailable = []VideoFormat{AV1, HEVC, H264}
 
-func (c *VideoConfig) compress(ctx context.Context, client *immich.ClientSimple, asset immich.AssetResponseDto) (*os.File, error) {
+func (c *VideoConfig) compress(ctx context.Context, asset immich.AssetResponseDto, fileIn string) (*compressed, error) {
 	uuid, err := uuid.Parse(asset.Id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse uuid '%s': %w", asset.Id, err)
 	}
 
-	// Create temporary input file
-	fileIn, err := os.Create(filepath.Join(os.TempDir(), fmt.Sprintf("%s%s", uuid.String(), filepath.Ext(asset.OriginalPath))))
+	// Create temporary output file
+	fileOutPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s-compressed.%s", uuid.String(), string(c.Container)))
+
+	source, err := probeStreams(ctx, fileIn)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Video: %s %s\n", source.video, asset.OriginalFileName)
+	err = c.checkEfficient(source)
+	if err != nil {
+		return nil, err
+	}
+	err = c.checkHDR(source)
+	if err != nil {
+		return nil, err
+	}
+	codecArgs, err := c.codecArgs(source)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp input file: %w", err)
+		return nil, err
+	}
+	streamArgs, err := c.streamArgs(source)
+	if err != nil {
+		return nil, err
 	}
-	defer fileIn.Close()
-	defer os.Remove(fileIn.Name())
 
-	// Create temporary output file
-	fileOutPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s-compressed.%s", uuid.String(), string(c.Container)))
+	crf := c.Quality
+	var quality string
+	if c.TargetVMAF > 0 {
+		crf, err = c.searchCRF(ctx, asset, fileIn, codecArgs, source)
+		if err != nil {
+			return nil, err
+		}
+		quality = fmt.Sprintf("%s-crf%d", c.Format, crf)
+	}
+
+	metadataOrig, err := probeMetadata(ctx, fileIn)
+	if err != nil {
+		return nil, err
+	}
+
+	args := c.encodeArgs(fileIn, fileOutPath, codecArgs, streamArgs, crf)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
-	// Download video to temporary input file
-	resp, err := client.AssetDownload(uuid)
+	// Run the command and capture its output
+	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch video: %w", err)
+		return nil, fmt.Errorf("ffmpeg failed with output '%s' %w", string(output), err)
 	}
-	defer resp.Body.Close()
 
-	// Copy downloaded video to input file
-	_, err = io.Copy(fileIn, resp.Body)
+	// Reject the encode when dates, location or orientation got lost
+	metadataNew, err := probeMetadata(ctx, fileOutPath)
+	if err != nil {
+		os.Remove(fileOutPath)
+		return nil, err
+	}
+	if lost := metadataOrig.lostIn(metadataNew); len(lost) > 0 {
+		os.Remove(fileOutPath)
+		return nil, skipAsset("metadata lost: %s", strings.Join(lost, ", "))
+	}
+
+	// Create temporary output file
+	fileOut, err := os.Open(fileOutPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save video to temp file: %w", err)
+		return nil, fmt.Errorf("failed to open temp output file: %w", err)
 	}
-	fileIn.Close() // Close to ensure all data is written
 
-	args := make([]string, 0, 20)
+	return &compressed{file: fileOut, quality: quality}, nil
+}
+
+// encodeArgs returns the whole ffmpeg command line of an encode
+func (c *VideoConfig) encodeArgs(fileIn, fileOut string, codecArgs, streamArgs []string, crf int) []string {
+	args := make([]string, 0, 30)
 	args = append(args,
-		"-i", fileIn.Name(),
+		"-i", fileIn,
 	)
+	args = append(args, codecArgs...)
+	// The per track options of streamArgs have to follow the -c:a of
+	// codecArgs, ffmpeg applies the last option that matches a stream
+	args = append(args, streamArgs...)
+	args = append(args, c.metadataArgs()...)
 
+	// -i: input file
+	// -c:v libsvtav1: Use the SVT-AV1 video codec
+	// -crf 30: Constant Rate Factor (quality). Lower is better quality,
+	//          higher is smaller file. 25-35 is a good range.
+	// -preset 8: SVT-AV1 speed preset. 0 (slowest, best quality)
+	//            to 12 (fastest, lowest quality). 5-8 is a good balance.
+	// -c:a libopus: Use the Opus audio codec, a great companion for AV1.
+	// -b:a 128k: Set audio bitrate to 128kbps.
+	args = append(args, []string{
+		"-crf", strconv.Itoa(crf), // Was 30. Lower is higher quality.
+		fileOut,
+	}...)
+	return args
+}
+
+// codecArgs returns the ffmpeg encoder arguments of the configured format
+// with the video filters for the source
+func (c *VideoConfig) codecArgs(source mediaStreams) ([]string, error) {
+	args := make([]string, 0, 12)
 	switch c.Format {
 	case AV1:
 		args = append(args,
@@ -117,33 +213,70 @@ func (c *VideoConfig) compress(ctx context.Context, client *immich.ClientSimple,
 	default:
 		return nil, fmt.Errorf("unsupported output format: %s", c.Format)
 	}
+	args = append(args, c.colorArgs(source)...)
+	args = append(args, c.encoderArgs(source)...)
 
-	// -i: input file
-	// -c:v libsvtav1: Use the SVT-AV1 video codec
-	// -crf 30: Constant Rate Factor (quality). Lower is better quality,
-	//          higher is smaller file. 25-35 is a good range.
-	// -preset 8: SVT-AV1 speed preset. 0 (slowest, best quality)
-	//            to 12 (fastest, lowest quality). 5-8 is a good balance.
-	// -c:a libopus: Use the Opus audio codec, a great companion for AV1.
-	// -b:a 128k: Set audio bitrate to 128kbps.
-	args = append(args, []string{
-		"-crf", strconv.Itoa(c.Quality), // Was 30. Lower is higher quality.
-		fileOutPath,
-	}...)
+	if filters := c.videoFilters(source); len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
 
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	return args, nil
+}
 
-	// Run the command and capture its output
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("ffmpeg failed with output '%s' %w", string(output), err)
+// encoderArgs returns the thread count and the encoder specific parameters
+func (c *VideoConfig) encoderArgs(source mediaStreams) []string {
+	var args []string
+	params := c.hdrParams(source)
+	if c.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(c.Threads))
+		if c.Format == HEVC {
+			// x265 sizes its thread pool itself and ignores -threads
+			params = append([]string{"pools=" + strconv.Itoa(c.Threads)}, params...)
+		}
+	}
+	if len(params) > 0 {
+		switch c.Format {
+		case HEVC:
+			args = append(args, "-x265-params", strings.Join(params, ":"))
+		case AV1:
+			args = append(args, "-svtav1-params", strings.Join(params, ":"))
+		}
 	}
+	return args
+}
 
-	// Create temporary output file
-	fileOut, err := os.Open(fileOutPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open temp output file: %w", err)
+// videoFilters returns the ffmpeg video filters of the configuration
+func (c *VideoConfig) videoFilters(source mediaStreams) []string {
+	var filters []string
+	// Dropping frames first leaves less to scale
+	if fps := c.fpsFilter(source); fps != "" {
+		filters = append(filters, fps)
+	}
+	if c.MaxHeight > 0 {
+		// ffmpeg rotates before the filters, so iw and ih are the displayed
+		// size. min() never upscales, -2 keeps the aspect ratio with an even
+		// size. Commas inside the expressions are escaped for the filter graph.
+		h := strconv.Itoa(c.MaxHeight)
+		filters = append(filters, fmt.Sprintf(
+			`scale=w=if(gte(iw\,ih)\,-2\,min(iw\,%s)):h=if(gte(iw\,ih)\,min(ih\,%s)\,-2)`, h, h))
 	}
+	// Tone-mapping last works on the fewest pixels
+	if c.tonemaps(source) {
+		filters = append(filters, tonemapFilter)
+	}
+	return filters
+}
 
-	return fileOut, nil
+// referenceFilter returns the filters that make the original comparable to
+// an encode for VMAF: the same frames and, when tone-mapped, the same
+// dynamic range. Scaling is left to the VMAF filter graph.
+func (c *VideoConfig) referenceFilter(source mediaStreams) string {
+	var filters []string
+	if fps := c.fpsFilter(source); fps != "" {
+		filters = append(filters, fps)
+	}
+	if c.tonemaps(source) {
+		filters = append(filters, tonemapFilter)
+	}
+	return strings.Join(filters, ",")
 }
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"testing"
 
@@ -48,7 +49,7 @@ func TestVideoConfigCompressInvalidUUID(t *testing.T) {
 	// Test with invalid UUID
 	asset := createTestAsset("invalid-uuid", "VIDEO", "video.mp4")
 
-	_, err := config.compress(ctx, nil, asset)
+	_, err := config.compress(ctx, asset, "")
 	if err == nil {
 		t.Error("Expected error for invalid UUID, got nil")
 	}
@@ -226,6 +227,43 @@ func TestVideoConfigFFmpegAV1(t *testing.T) {
 	}
 }
 
+func TestVideoConfigVideoFilters(t *testing.T) {
+	config := VideoConfig{Format: AV1, Container: MKV, Quality: 30}
+	if filters := config.videoFilters(mediaStreams{}); len(filters) != 0 {
+		t.Errorf("Expected no filters without a cap, got %v", filters)
+	}
+	args, err := config.codecArgs(mediaStreams{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if slices.Contains(args, "-vf") {
+		t.Errorf("Expected no -vf without a cap, got %v", args)
+	}
+
+	config.MaxHeight = 1080
+	expected := `scale=w=if(gte(iw\,ih)\,-2\,min(iw\,1080)):h=if(gte(iw\,ih)\,min(ih\,1080)\,-2)`
+	filters := config.videoFilters(mediaStreams{})
+	if len(filters) != 1 || filters[0] != expected {
+		t.Errorf("Expected %q, got %v", expected, filters)
+	}
+	args, err = config.codecArgs(mediaStreams{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	i := slices.Index(args, "-vf")
+	if i < 0 || i+1 >= len(args) || args[i+1] != expected {
+		t.Errorf("Expected -vf %q in %v", expected, args)
+	}
+}
+
+func TestVideoConfigVideoFiltersFPSFirst(t *testing.T) {
+	config := VideoConfig{MaxHeight: 720, MaxFPS: 30}
+	filters := config.videoFilters(mediaStreams{video: videoStream{fps: 60}})
+	if len(filters) != 2 || filters[0] != "fps=30" {
+		t.Errorf("Expected the fps filter before scaling, got %v", filters)
+	}
+}
+
 func TestVideoFormatsAvailable(t *testing.T) {
 	expectedFormats := []VideoFormat{AV1, HEVC, H264}
 	actualFormats := VideoFormatsAvailable
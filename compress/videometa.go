@@ -0,0 +1,193 @@
+package compress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// videoMetadata is the metadata of a video that has to survive the encode
+type videoMetadata struct {
+	creationTime string
+	location     string
+	portrait     bool
+	// vendor holds the Apple and Android specific tags
+	vendor map[string]string
+}
+
+// ffprobeOutput is the part of `ffprobe -print_format json` that is used
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType      string            `json:"codec_type"`
+		CodecName      string            `json:"codec_name"`
+		BitRate        string            `json:"bit_rate"`
+		Channels       int               `json:"channels"`
+		AvgFrameRate   string            `json:"avg_frame_rate"`
+		PixFmt         string            `json:"pix_fmt"`
+		ColorTransfer  string            `json:"color_transfer"`
+		ColorPrimaries string            `json:"color_primaries"`
+		ColorSpace     string            `json:"color_space"`
+		ColorRange     string            `json:"color_range"`
+		Width          int               `json:"width"`
+		Height         int               `json:"height"`
+		Tags           map[string]string `json:"tags"`
+		SideDataList   []ffprobeSideData `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		BitRate string            `json:"bit_rate"`
+		Tags    map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// ffprobeSideData is a side data entry of a stream, the fields depend on
+// its side_data_type
+type ffprobeSideData struct {
+	SideDataType string  `json:"side_data_type"`
+	Rotation     float64 `json:"rotation"`
+	// Mastering display metadata, rationals like 34000/50000
+	RedX         string `json:"red_x"`
+	RedY         string `json:"red_y"`
+	GreenX       string `json:"green_x"`
+	GreenY       string `json:"green_y"`
+	BlueX        string `json:"blue_x"`
+	BlueY        string `json:"blue_y"`
+	WhitePointX  string `json:"white_point_x"`
+	WhitePointY  string `json:"white_point_y"`
+	MinLuminance string `json:"min_luminance"`
+	MaxLuminance string `json:"max_luminance"`
+	// Content light level metadata
+	MaxContent int `json:"max_content"`
+	MaxAverage int `json:"max_average"`
+}
+
+// locationTags are the keys a location is stored under, the first one is
+// the ©xyz atom of MP4/MOV
+var locationTags = []string{"location", "com.apple.quicktime.location.iso6709"}
+
+var vendorTagPrefixes = []string{"com.apple.", "com.android."}
+
+// metadataArgs returns the ffmpeg arguments that copy the container, stream
+// and chapter metadata of the first input
+func (c *VideoConfig) metadataArgs() []string {
+	args := []string{
+		"-map_metadata", "0",
+		"-map_metadata:s:v", "0:s:v",
+		"-map_metadata:s:a", "0:s:a",
+		"-map_chapters", "0",
+	}
+	if c.Container == MP4 {
+		// Without it the MP4 muxer drops every tag it has no atom for
+		args = append(args, "-movflags", "use_metadata_tags")
+	}
+	return args
+}
+
+// probeMetadata reads the metadata of a video with ffprobe
+func probeMetadata(ctx context.Context, file string) (videoMetadata, error) {
+	output, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_entries", "format_tags:stream=codec_type,width,height:stream_tags:stream_side_data=rotation",
+		file,
+	).Output()
+	if err != nil {
+		return videoMetadata{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	return parseMetadata(output)
+}
+
+func parseMetadata(output []byte) (videoMetadata, error) {
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return videoMetadata{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	// Key case differs between containers
+	tags := make(map[string]string, len(probe.Format.Tags))
+	for key, value := range probe.Format.Tags {
+		tags[strings.ToLower(key)] = strings.TrimSpace(value)
+	}
+
+	metadata := videoMetadata{
+		creationTime: tags["creation_time"],
+		vendor:       map[string]string{},
+	}
+	for _, key := range locationTags {
+		if value := tags[key]; value != "" {
+			metadata.location = value
+			break
+		}
+	}
+	for key, value := range tags {
+		for _, prefix := range vendorTagPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				metadata.vendor[key] = value
+			}
+		}
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		rotation := 0.0
+		for _, sideData := range stream.SideDataList {
+			if sideData.Rotation != 0 {
+				rotation = sideData.Rotation
+			}
+		}
+		// Older ffprobe versions report the rotation as a tag
+		if rotate, err := strconv.ParseFloat(stream.Tags["rotate"], 64); err == nil && rotation == 0 {
+			rotation = rotate
+		}
+		width, height := stream.Width, stream.Height
+		if int(rotation)%180 != 0 {
+			width, height = height, width
+		}
+		metadata.portrait = height > width
+		break
+	}
+
+	return metadata, nil
+}
+
+// lostIn returns the names of the metadata that is set here but missing or
+// different in compressed
+func (m videoMetadata) lostIn(compressed videoMetadata) []string {
+	var lost []string
+	if m.creationTime != "" && !sameTime(m.creationTime, compressed.creationTime) {
+		lost = append(lost, "creation_time")
+	}
+	if m.location != "" && m.location != compressed.location {
+		lost = append(lost, "location")
+	}
+	// ffmpeg rotates the pixels on encode, so only the displayed
+	// orientation has to match
+	if m.portrait != compressed.portrait {
+		lost = append(lost, "rotation")
+	}
+	var vendorLost []string
+	for key, value := range m.vendor {
+		if compressed.vendor[key] != value {
+			vendorLost = append(vendorLost, key)
+		}
+	}
+	slices.Sort(vendorLost)
+	return append(lost, vendorLost...)
+}
+
+// sameTime compares two timestamps to the second, containers store them
+// with different precision
+func sameTime(a, b string) bool {
+	timeA, errA := time.Parse(time.RFC3339Nano, a)
+	timeB, errB := time.Parse(time.RFC3339Nano, b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return timeA.Truncate(time.Second).Equal(timeB.Truncate(time.Second))
+}
@@ -0,0 +1,117 @@
+package compress
+
+import (
+	"slices"
+	"testing"
+)
+
+const ffprobeIPhone = `{
+	"streams": [
+		{
+			"codec_type": "video",
+			"width": 1920,
+			"height": 1080,
+			"side_data_list": [{"rotation": -90}]
+		},
+		{"codec_type": "audio"}
+	],
+	"format": {
+		"tags": {
+			"creation_time": "2024-05-01T10:20:30.000000Z",
+			"com.apple.quicktime.location.ISO6709": "+52.5200+013.4050+034.000/",
+			"com.apple.quicktime.make": "Apple",
+			"com.apple.quicktime.model": "iPhone 13"
+		}
+	}
+}`
+
+func TestParseMetadata(t *testing.T) {
+	metadata, err := parseMetadata([]byte(ffprobeIPhone))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if metadata.creationTime != "2024-05-01T10:20:30.000000Z" {
+		t.Errorf("Unexpected creation time %q", metadata.creationTime)
+	}
+	if metadata.location != "+52.5200+013.4050+034.000/" {
+		t.Errorf("Unexpected location %q", metadata.location)
+	}
+	if !metadata.portrait {
+		t.Error("Expected a rotated landscape stream to be displayed as portrait")
+	}
+	if metadata.vendor["com.apple.quicktime.model"] != "iPhone 13" {
+		t.Errorf("Expected vendor tags to be kept, got %v", metadata.vendor)
+	}
+}
+
+func TestParseMetadataInvalid(t *testing.T) {
+	if _, err := parseMetadata([]byte("not json")); err == nil {
+		t.Error("Expected error for invalid ffprobe output, got nil")
+	}
+}
+
+func TestVideoMetadataLostIn(t *testing.T) {
+	orig := videoMetadata{
+		creationTime: "2024-05-01T10:20:30.000000Z",
+		location:     "+52.5200+013.4050/",
+		portrait:     true,
+		vendor:       map[string]string{"com.apple.quicktime.make": "Apple"},
+	}
+
+	tests := []struct {
+		name       string
+		compressed videoMetadata
+		expected   []string
+	}{
+		{
+			name: "everything kept",
+			compressed: videoMetadata{
+				creationTime: "2024-05-01T10:20:30Z",
+				location:     "+52.5200+013.4050/",
+				portrait:     true,
+				vendor:       map[string]string{"com.apple.quicktime.make": "Apple"},
+			},
+		},
+		{
+			name:       "everything lost",
+			compressed: videoMetadata{vendor: map[string]string{}},
+			expected:   []string{"creation_time", "location", "rotation", "com.apple.quicktime.make"},
+		},
+		{
+			name: "different date",
+			compressed: videoMetadata{
+				creationTime: "2025-01-01T00:00:00Z",
+				location:     "+52.5200+013.4050/",
+				portrait:     true,
+				vendor:       map[string]string{"com.apple.quicktime.make": "Apple"},
+			},
+			expected: []string{"creation_time"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lost := orig.lostIn(tt.compressed)
+			if !slices.Equal(lost, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, lost)
+			}
+		})
+	}
+}
+
+func TestVideoConfigMetadataArgs(t *testing.T) {
+	mp4 := VideoConfig{Container: MP4}
+	if !slices.Contains(mp4.metadataArgs(), "use_metadata_tags") {
+		t.Error("Expected MP4 to keep custom metadata tags")
+	}
+
+	mkv := VideoConfig{Container: MKV}
+	args := mkv.metadataArgs()
+	if slices.Contains(args, "-movflags") {
+		t.Error("Expected no movflags for MKV")
+	}
+	if !slices.Contains(args, "-map_metadata") || !slices.Contains(args, "-map_chapters") {
+		t.Errorf("Expected metadata and chapters to be mapped, got %v", args)
+	}
+}
@@ -0,0 +1,168 @@
+package compress
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"immich-compress/immich"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// vmafSamples is the number of segments that are encoded per candidate
+	vmafSamples = 3
+	// vmafSampleSeconds is the length of one segment
+	vmafSampleSeconds = 4.0
+)
+
+// VMAFMax is the VMAF score of a video identical to its original
+const VMAFMax = 100.0
+
+var vmafScoreRegexp = regexp.MustCompile(`VMAF score[:=]\s*([0-9.]+)`)
+
+// searchCRF binary searches the highest CRF between MinCRF and MaxCRF whose
+// sample segments reach TargetVMAF on average. A higher CRF gives a smaller
+// file, so this is the smallest acceptable encode. source describes the
+// streams of fileIn.
+func (c *VideoConfig) searchCRF(ctx context.Context, asset immich.AssetResponseDto, fileIn string, codecArgs []string, source mediaStreams) (int, error) {
+	if c.MinCRF > c.MaxCRF {
+		return 0, fmt.Errorf("min crf %d is higher than max crf %d", c.MinCRF, c.MaxCRF)
+	}
+	uuid, err := uuid.Parse(asset.Id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse uuid '%s': %w", asset.Id, err)
+	}
+
+	duration, err := probeDuration(ctx, fileIn)
+	if err != nil {
+		return 0, err
+	}
+	starts := sampleStarts(duration, vmafSamples, vmafSampleSeconds)
+
+	best := -1
+	bestScore := 0.0
+	low, high := c.MinCRF, c.MaxCRF
+	for low <= high {
+		crf := (low + high) / 2
+		score, err := c.sampleVMAF(ctx, fileIn, uuid.String(), codecArgs, c.referenceFilter(source), crf, starts)
+		if err != nil {
+			return 0, err
+		}
+		if score >= c.TargetVMAF {
+			best, bestScore = crf, score
+			low = crf + 1
+		} else {
+			high = crf - 1
+		}
+	}
+
+	if best < 0 {
+		return 0, skipAsset("vmaf %.2f is not reachable with crf %d", c.TargetVMAF, c.MinCRF)
+	}
+	fmt.Printf("CRF: %d (vmaf %.2f) %s\n", best, bestScore, asset.OriginalFileName)
+
+	return best, nil
+}
+
+// sampleVMAF encodes every sample segment at crf and returns their mean
+// VMAF score against the original. referenceFilter is applied to the
+// original, so both have the same frames and dynamic range.
+func (c *VideoConfig) sampleVMAF(ctx context.Context, fileIn, name string, codecArgs []string, referenceFilter string, crf int, starts []float64) (float64, error) {
+	var total float64
+	for i, start := range starts {
+		sampleOut := filepath.Join(os.TempDir(), fmt.Sprintf("%s-sample-%d.%s", name, i, c.Container))
+		score, err := encodeSampleVMAF(ctx, fileIn, sampleOut, codecArgs, referenceFilter, crf, start)
+		os.Remove(sampleOut)
+		if err != nil {
+			return 0, err
+		}
+		total += score
+	}
+	return total / float64(len(starts)), nil
+}
+
+// encodeSampleVMAF encodes one segment of the original without audio and
+// scores it with libvmaf
+func encodeSampleVMAF(ctx context.Context, fileIn, sampleOut string, codecArgs []string, referenceFilter string, crf int, start float64) (float64, error) {
+	seek := strconv.FormatFloat(start, 'f', 3, 64)
+	length := strconv.FormatFloat(vmafSampleSeconds, 'f', 3, 64)
+
+	args := make([]string, 0, 24)
+	args = append(args, "-y", "-ss", seek, "-t", length, "-i", fileIn)
+	args = append(args, codecArgs...)
+	args = append(args, "-an", "-crf", strconv.Itoa(crf), sampleOut)
+	output, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg sample encode failed with output '%s' %w", string(output), err)
+	}
+
+	reference := "setpts=PTS-STARTPTS"
+	if referenceFilter != "" {
+		reference = referenceFilter + "," + reference
+	}
+	// libvmaf takes the distorted stream first and the reference second.
+	// A downscaled sample is scaled back to the size of the reference.
+	output, err = exec.CommandContext(ctx, "ffmpeg",
+		"-i", sampleOut,
+		"-ss", seek, "-t", length, "-i", fileIn,
+		"-lavfi", "[0:v]setpts=PTS-STARTPTS[sample];[1:v]"+reference+"[original];[sample][original]scale2ref=flags=bicubic[distorted][reference];[distorted][reference]libvmaf",
+		"-f", "null", "-",
+	).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg vmaf failed with output '%s' %w", string(output), err)
+	}
+
+	return parseVMAF(string(output))
+}
+
+// parseVMAF reads the pooled score that libvmaf prints at the end
+func parseVMAF(output string) (float64, error) {
+	matches := vmafScoreRegexp.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("no vmaf score in ffmpeg output")
+	}
+	score, err := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse vmaf score: %w", err)
+	}
+	return score, nil
+}
+
+// probeDuration returns the duration of a media file in seconds
+func probeDuration(ctx context.Context, file string) (float64, error) {
+	output, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		file,
+	).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse duration '%s': %w", strings.TrimSpace(string(output)), err)
+	}
+	return duration, nil
+}
+
+// sampleStarts spreads count segments of length seconds evenly over the
+// clip. A clip too short for that is sampled once from the beginning.
+func sampleStarts(duration float64, count int, length float64) []float64 {
+	if duration <= length*float64(count) {
+		return []float64{0}
+	}
+	starts := make([]float64, 0, count)
+	step := duration / float64(count+1)
+	for i := 1; i <= count; i++ {
+		starts = append(starts, step*float64(i)-length/2)
+	}
+	return starts
+}
@@ -0,0 +1,66 @@
+package compress
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseVMAF(t *testing.T) {
+	output := `[Parsed_libvmaf_2 @ 0x5581] VMAF score: 93.456789
+[out#0/null @ 0x5581] video:1kB audio:0kB`
+
+	score, err := parseVMAF(output)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if score != 93.456789 {
+		t.Errorf("Expected score 93.456789, got %v", score)
+	}
+}
+
+func TestParseVMAFMissing(t *testing.T) {
+	if _, err := parseVMAF("Conversion failed!"); err == nil {
+		t.Error("Expected error when there is no score, got nil")
+	}
+}
+
+func TestSampleStarts(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		expected []float64
+	}{
+		{name: "short clip", duration: 10, expected: []float64{0}},
+		{name: "long clip", duration: 40, expected: []float64{8, 18, 28}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starts := sampleStarts(tt.duration, vmafSamples, vmafSampleSeconds)
+			if len(starts) != len(tt.expected) {
+				t.Fatalf("Expected %d starts, got %v", len(tt.expected), starts)
+			}
+			for i := range starts {
+				if starts[i] != tt.expected[i] {
+					t.Errorf("Expected start %v, got %v", tt.expected[i], starts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSearchCRFInvalidRange(t *testing.T) {
+	config := VideoConfig{
+		Container:  MKV,
+		Format:     AV1,
+		TargetVMAF: 95,
+		MinCRF:     40,
+		MaxCRF:     20,
+	}
+	asset := createTestAsset("invalid-uuid", "VIDEO", "video.mp4")
+
+	_, err := config.searchCRF(context.Background(), asset, "", nil, mediaStreams{})
+	if err == nil {
+		t.Error("Expected error for min crf above max crf, got nil")
+	}
+}
@@ -0,0 +1,119 @@
+package compress
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Workers limits how many assets each stage of the pipeline handles at
+// once. Downloads and uploads wait on the network, image and video encodes
+// on the CPU, so a slow upload does not hold back the encoders and AV1
+// encodes do not pile up. 0 picks the default.
+type Workers struct {
+	Download int
+	Image    int
+	Video    int
+	Upload   int
+	// VideoThreads is the number of threads of one ffmpeg encode
+	VideoThreads int
+}
+
+// videoCPUsPerWorker is the share of the CPUs one video encode gets by
+// default. The encoders scale well up to about this many threads.
+const videoCPUsPerWorker = 8
+
+// withDefaults fills the unset limits. The network stages get parallel
+// workers, images one per CPU and videos one per videoCPUsPerWorker CPUs,
+// both at most parallel, and the video encodes split the CPUs between them.
+func (w Workers) withDefaults(parallel, cpus int) Workers {
+	if w.Download <= 0 {
+		w.Download = parallel
+	}
+	if w.Upload <= 0 {
+		w.Upload = parallel
+	}
+	if w.Image <= 0 {
+		w.Image = max(1, min(cpus, parallel))
+	}
+	if w.Video <= 0 {
+		w.Video = max(1, min(cpus/videoCPUsPerWorker, parallel))
+	}
+	if w.VideoThreads <= 0 {
+		w.VideoThreads = max(1, cpus/w.Video)
+	}
+	return w
+}
+
+func (w Workers) validate() error {
+	if w.Download < 0 || w.Image < 0 || w.Video < 0 || w.Upload < 0 || w.VideoThreads < 0 {
+		return fmt.Errorf("workers and threads can not be negative")
+	}
+	return nil
+}
+
+// inFlight is the number of assets in the pipeline at once. An asset that
+// waits for the next stage keeps its temporary files, so this bounds the
+// queues between the stages and the disk space they take.
+func (w Workers) inFlight() int {
+	return w.Download + w.Image + w.Video + w.Upload
+}
+
+// stage is a step of the pipeline with its own worker limit
+type stage int
+
+const (
+	stageDownload stage = iota
+	stageImage
+	stageVideo
+	stageUpload
+	stageCount
+)
+
+var stageNames = [stageCount]string{"download", "image encode", "video encode", "upload"}
+
+func (s stage) String() string {
+	return stageNames[s]
+}
+
+// stageError is an error of a stage, the failure report names the stage
+type stageError struct {
+	stage stage
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// stages holds the free workers of every stage
+type stages [stageCount]*semaphore.Weighted
+
+func newStages(w Workers) *stages {
+	return &stages{
+		stageDownload: semaphore.NewWeighted(int64(w.Download)),
+		stageImage:    semaphore.NewWeighted(int64(w.Image)),
+		stageVideo:    semaphore.NewWeighted(int64(w.Video)),
+		stageUpload:   semaphore.NewWeighted(int64(w.Upload)),
+	}
+}
+
+// run waits for a free worker of the stage and runs fn on it. Without
+// stages fn runs right away. Errors of fn are marked with the stage.
+func (s *stages) run(ctx context.Context, st stage, fn func() error) error {
+	if s != nil {
+		if err := s[st].Acquire(ctx, 1); err != nil {
+			return err
+		}
+		defer s[st].Release(1)
+	}
+	if err := fn(); err != nil {
+		return &stageError{stage: st, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package compress
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkersWithDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		workers  Workers
+		expected Workers
+	}{
+		{
+			name:     "all defaults",
+			workers:  Workers{},
+			expected: Workers{Download: 4, Image: 4, Video: 2, Upload: 4, VideoThreads: 8},
+		},
+		{
+			name:     "threads follow the video workers",
+			workers:  Workers{Video: 4},
+			expected: Workers{Download: 4, Image: 4, Video: 4, Upload: 4, VideoThreads: 4},
+		},
+		{
+			name:     "explicit values",
+			workers:  Workers{Download: 1, Image: 2, Video: 3, Upload: 5, VideoThreads: 6},
+			expected: Workers{Download: 1, Image: 2, Video: 3, Upload: 5, VideoThreads: 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.workers.withDefaults(4, 16); got != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+
+	// --parallel caps the encoders, the video encode gets every CPU
+	if got := (Workers{}).withDefaults(1, 16); got.Image != 1 || got.Video != 1 || got.VideoThreads != 16 {
+		t.Errorf("Expected one image and one video worker with 16 threads, got %+v", got)
+	}
+
+	// A small machine still gets one video worker with every CPU
+	if got := (Workers{}).withDefaults(2, 2); got.Video != 1 || got.VideoThreads != 2 {
+		t.Errorf("Expected one video worker with 2 threads, got %+v", got)
+	}
+}
+
+func TestWorkersValidate(t *testing.T) {
+	if err := (Workers{}).validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := (Workers{Upload: -1}).validate(); err == nil {
+		t.Error("Expected an error for negative workers")
+	}
+}
+
+func TestWorkersInFlight(t *testing.T) {
+	workers := Workers{Download: 4, Image: 8, Video: 1, Upload: 2}
+	if got := workers.inFlight(); got != 15 {
+		t.Errorf("Expected 15 assets in flight, got %d", got)
+	}
+}
+
+func TestStagesRunLimit(t *testing.T) {
+	stages := newStages(Workers{Download: 1, Image: 1, Video: 2, Upload: 1})
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := stages.run(context.Background(), stageVideo, func() error {
+				now := atomic.AddInt32(&running, 1)
+				for {
+					old := atomic.LoadInt32(&peak)
+					if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak != 2 {
+		t.Errorf("Expected at most 2 video encodes at once, got %d", peak)
+	}
+}
+
+func TestStagesRunCancelled(t *testing.T) {
+	stages := newStages(Workers{Download: 1, Image: 1, Video: 1, Upload: 1})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go stages.run(context.Background(), stageUpload, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	defer close(release)
+	<-started
+
+	// A cancelled run must not wait for the busy worker
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := stages.run(ctx, stageUpload, func() error {
+		t.Error("Expected fn not to run")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestStagesRunNil(t *testing.T) {
+	var stages *stages
+	ran := false
+	err := stages.run(context.Background(), stageImage, func() error {
+		ran = true
+		return nil
+	})
+	if err != nil || !ran {
+		t.Errorf("Expected fn to run without stages, got %v", err)
+	}
+}
+
+func TestStagesRunStageError(t *testing.T) {
+	err := newStages(Workers{Download: 1, Image: 1, Video: 1, Upload: 1}).run(context.Background(), stageVideo, func() error {
+		return skipAsset("too small")
+	})
+	var staged *stageError
+	if !errors.As(err, &staged) || staged.stage != stageVideo || staged.stage.String() != "video encode" {
+		t.Errorf("Expected a video encode error, got %v", err)
+	}
+	var skip *skipError
+	if !errors.As(err, &skip) {
+		t.Errorf("Expected the skip to stay visible, got %v", err)
+	}
+}
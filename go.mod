@@ -7,7 +7,10 @@ require (
 	github.com/spf13/cobra v1.10.1
 )
 
-require github.com/cshum/vipsgen v1.2.1
+require (
+	github.com/cshum/vipsgen v1.2.1
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require github.com/stretchr/testify v1.11.1 // indirect
 
@@ -0,0 +1,28 @@
+package immich
+
+import (
+	"context"
+	"testing"
+
+	"immich-compress/immich/immichtest"
+)
+
+// fakeServer is the shared fake Immich server with a client of this package
+type fakeServer struct {
+	*immichtest.Server
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	return &fakeServer{immichtest.NewServer(t)}
+}
+
+// client returns a client of the fake server without retries
+func (f *fakeServer) client(t *testing.T) *ClientSimple {
+	t.Helper()
+	client, err := NewClientWithResponses(f.URL)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return &ClientSimple{client: client, clientRaw: client.ClientInterface, ctx: context.Background(), parallel: 2}
+}
@@ -0,0 +1,86 @@
+// Package immichtest provides a fake Immich server for the tests of the
+// packages talking to the Immich API.
+package immichtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Request is a request the fake server received
+type Request struct {
+	Method, Path string
+	Body         []byte
+}
+
+// Server answers Immich API requests from handlers registered per
+// "METHOD /path" and records every request. Unknown routes answer 404.
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	requests []Request
+}
+
+// NewServer starts a fake server that is closed when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	f := &Server{handlers: map[string]http.HandlerFunc{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		route := r.Method + " " + r.URL.Path
+		f.mu.Lock()
+		f.requests = append(f.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+		handler, ok := f.handlers[route]
+		f.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Handle registers the handler of a route like "GET /tags"
+func (f *Server) Handle(route string, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[route] = handler
+}
+
+// JSON answers a route with status and body as JSON
+func (f *Server) JSON(route string, status int, body any) {
+	f.Handle(route, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
+
+// Received returns the requests of a route
+func (f *Server) Received(method, path string) []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []Request
+	for _, r := range f.requests {
+		if r.Method == method && r.Path == path {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// All returns every request received so far
+func (f *Server) All() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
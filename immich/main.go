@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/oapi-codegen/runtime/types"
@@ -16,32 +17,34 @@ type ClientSimple struct {
 	ctx       context.Context
 	parallel  int
 	tags      struct {
-		compressedID types.UUID
+		// compressedID is nil until the tag is needed, a dry run must not
+		// create it
+		compressedID *types.UUID
+		runID        *types.UUID
+		mu           sync.Mutex
+	}
+	stacks struct {
+		byAsset map[string]*AssetStackResponseDto
+		mu      sync.Mutex
 	}
 }
 
-func NewClientSimple(ctx context.Context, parralel int, baseURL string, apiKey string) (*ClientSimple, error) {
+func NewClientSimple(ctx context.Context, parralel int, baseURL string, apiKey string, retry Retry) (*ClientSimple, error) {
+	if err := retry.validate(); err != nil {
+		return nil, err
+	}
 	// Create a new client.
 	// You must provide an http.Client that adds the API key to every request.
 	client, err := NewClientWithResponses(baseURL, WithRequestEditorFn(
 		func(ctx context.Context, req *http.Request) error {
 			req.Header.Set("x-api-key", apiKey)
 			return nil
-		}))
+		}), WithHTTPClient(&retryDoer{doer: &http.Client{}, retry: retry}))
 	if err != nil {
 		return nil, fmt.Errorf("error creating client: %w", err)
 	}
 
-	clientSimple := &ClientSimple{client: client, clientRaw: client.ClientInterface, ctx: ctx, parallel: parralel}
-
-	tagCompressedAtID, err := clientSimple.tagCompressedAt()
-	if err != nil {
-		return nil, fmt.Errorf("can not get/create tags: %w", err)
-	}
-
-	clientSimple.tags = struct{ compressedID types.UUID }{compressedID: tagCompressedAtID}
-
-	return clientSimple, nil
+	return &ClientSimple{client: client, clientRaw: client.ClientInterface, ctx: ctx, parallel: parralel}, nil
 }
 
 func UUUIDOfString(id string) (types.UUID, error) {
@@ -0,0 +1,34 @@
+package immich
+
+import (
+	"fmt"
+
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// AlbumFind returns the id of the owned or shared album with this name
+func (c *ClientSimple) AlbumFind(name string) (types.UUID, error) {
+	var uuid types.UUID
+	resp, err := c.client.GetAllAlbumsWithResponse(c.ctx, &GetAllAlbumsParams{})
+	if err != nil {
+		return uuid, fmt.Errorf("failed to get albums: %w", err)
+	}
+	if resp.JSON200 == nil {
+		return uuid, fmt.Errorf("albums request failed with status %d: %s", resp.StatusCode(), resp.Status())
+	}
+
+	var found []string
+	for _, album := range *resp.JSON200 {
+		if album.AlbumName == name {
+			found = append(found, album.Id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return uuid, fmt.Errorf("album '%s' not found", name)
+	case 1:
+		return UUUIDOfString(found[0])
+	default:
+		return uuid, fmt.Errorf("album name '%s' is ambiguous, %d albums have it", name, len(found))
+	}
+}
@@ -0,0 +1,100 @@
+package immich
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+// ChecksumOf returns the SHA1 of everything read from r, base64 encoded
+// like AssetResponseDto.Checksum
+func ChecksumOf(r io.Reader) (string, error) {
+	hash := sha1.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fileChecksum returns the checksum of a whole file and rewinds it for the
+// upload
+func fileChecksum(file *os.File) (string, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind '%s': %w", file.Name(), err)
+	}
+	checksum, err := ChecksumOf(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash '%s': %w", file.Name(), err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind '%s': %w", file.Name(), err)
+	}
+	return checksum, nil
+}
+
+// AssetFindByChecksum asks the server whether the library holds an asset
+// with the checksum already. It returns that asset, nil when there is none.
+func (c *ClientSimple) AssetFindByChecksum(checksum string) (*AssetBulkUploadCheckResult, error) {
+	r, err := c.client.CheckBulkUploadWithResponse(retrySafe(c.ctx), CheckBulkUploadJSONRequestBody{
+		Assets: []AssetBulkUploadCheckItem{{Id: checksum, Checksum: checksum}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for duplicates: %w", err)
+	}
+	if r.JSON200 == nil {
+		return nil, fmt.Errorf("bad status code: %s, body: %s", r.Status(), string(r.Body))
+	}
+	return duplicateOf(r.JSON200.Results), nil
+}
+
+// duplicateOf returns the result that names an existing asset
+func duplicateOf(results []AssetBulkUploadCheckResult) *AssetBulkUploadCheckResult {
+	for _, result := range results {
+		if result.Action == Reject && result.Reason != nil && *result.Reason == AssetBulkUploadCheckResultReasonDuplicate && result.AssetId != nil {
+			return &result
+		}
+	}
+	return nil
+}
+
+// uploadedAsset reads the answer of an upload. The server answers a new
+// asset with 201 and a duplicate with 200, which the generated client does
+// not parse.
+func uploadedAsset(r *UploadAssetResponse) (*AssetMediaResponseDto, error) {
+	if r.JSON201 != nil {
+		return r.JSON201, nil
+	}
+	if r.StatusCode() == http.StatusOK {
+		var dto AssetMediaResponseDto
+		if err := json.Unmarshal(r.Body, &dto); err != nil {
+			return nil, fmt.Errorf("failed to parse upload response: %w", err)
+		}
+		return &dto, nil
+	}
+	return nil, fmt.Errorf("bad status code: %s, body: %s", r.Status(), string(r.Body))
+}
+
+// reuseDuplicate makes an existing copy usable as the compressed asset, a
+// trashed one is restored first
+func (c *ClientSimple) reuseDuplicate(asset AssetResponseDto, duplicate AssetBulkUploadCheckResult) (openapi_types.UUID, error) {
+	uuidDuplicate, err := UUUIDOfString(*duplicate.AssetId)
+	if err != nil {
+		return uuidDuplicate, err
+	}
+	if *duplicate.AssetId == asset.Id {
+		return uuidDuplicate, fmt.Errorf("compressed copy of '%s' is identical to the original", asset.OriginalFileName)
+	}
+	if duplicate.IsTrashed != nil && *duplicate.IsTrashed {
+		err = c.AssetRestore([]openapi_types.UUID{uuidDuplicate})
+		if err != nil {
+			return uuidDuplicate, err
+		}
+	}
+	return uuidDuplicate, nil
+}
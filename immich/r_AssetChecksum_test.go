@@ -0,0 +1,96 @@
+package immich
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksumOf(t *testing.T) {
+	// sha1("hello world") = 2aae6c35c94fcfb415dbe95f408b9ce91ee846ed
+	checksum, err := ChecksumOf(strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if checksum != "Kq5sNclPz7QV2+lfQIuc6R7oRu0=" {
+		t.Errorf("Unexpected checksum %s", checksum)
+	}
+}
+
+func TestFileChecksumRewinds(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "compressed.jxl"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString("hello world"); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if checksum != "Kq5sNclPz7QV2+lfQIuc6R7oRu0=" {
+		t.Errorf("Expected the checksum of the whole file, got %s", checksum)
+	}
+	// The upload reads the file afterwards
+	again, err := ChecksumOf(file)
+	if err != nil || again != checksum {
+		t.Errorf("Expected the file to be rewound, got %s %v", again, err)
+	}
+}
+
+func TestDuplicateOf(t *testing.T) {
+	assetID := "0f8fad5b-d9cb-469f-a165-70867728950e"
+	duplicate := AssetBulkUploadCheckResultReasonDuplicate
+	unsupported := AssetBulkUploadCheckResultReasonUnsupportedFormat
+
+	if got := duplicateOf([]AssetBulkUploadCheckResult{{Action: Accept}}); got != nil {
+		t.Errorf("Expected no duplicate for an accepted upload, got %+v", got)
+	}
+	if got := duplicateOf([]AssetBulkUploadCheckResult{{Action: Reject, Reason: &unsupported}}); got != nil {
+		t.Errorf("Expected no duplicate for an unsupported format, got %+v", got)
+	}
+	got := duplicateOf([]AssetBulkUploadCheckResult{{Action: Reject, Reason: &duplicate, AssetId: &assetID}})
+	if got == nil || *got.AssetId != assetID {
+		t.Errorf("Expected duplicate %s, got %+v", assetID, got)
+	}
+}
+
+func TestUploadedAsset(t *testing.T) {
+	t.Run("created", func(t *testing.T) {
+		r := &UploadAssetResponse{
+			HTTPResponse: &http.Response{StatusCode: http.StatusCreated},
+			JSON201:      &AssetMediaResponseDto{Id: "new", Status: AssetMediaStatusCreated},
+		}
+		uploaded, err := uploadedAsset(r)
+		if err != nil || uploaded.Id != "new" {
+			t.Errorf("Expected the created asset, got %+v %v", uploaded, err)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		// Used to dereference the missing JSON201
+		r := &UploadAssetResponse{
+			HTTPResponse: &http.Response{StatusCode: http.StatusOK},
+			Body:         []byte(`{"id": "existing", "status": "duplicate"}`),
+		}
+		uploaded, err := uploadedAsset(r)
+		if err != nil || uploaded.Id != "existing" || uploaded.Status != AssetMediaStatusDuplicate {
+			t.Errorf("Expected the existing asset, got %+v %v", uploaded, err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		r := &UploadAssetResponse{
+			HTTPResponse: &http.Response{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"},
+			Body:         []byte(`{"message": "bad"}`),
+		}
+		if _, err := uploadedAsset(r); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package immich
+
+import (
+	"fmt"
+
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// METADATA_COMPRESS is the asset metadata key of the settings picked for a
+// compressed asset
+const METADATA_COMPRESS AssetMetadataKey = "immich-compress"
+
+// AssetCompressRecord records in the metadata of a compressed copy the
+// original it replaces and the encoder quality picked for it, like
+// {"original": "<id>", "quality": "jxl-q75"} below immich-compress. An empty
+// quality is left out.
+func (c *ClientSimple) AssetCompressRecord(assetID types.UUID, originalID string, quality string) error {
+	value := map[string]interface{}{"original": originalID}
+	if quality != "" {
+		value["quality"] = quality
+	}
+	r, err := c.client.UpdateAssetMetadataWithResponse(c.ctx, assetID, UpdateAssetMetadataJSONRequestBody{
+		Items: []AssetMetadataUpsertItemDto{{
+			Key:   METADATA_COMPRESS,
+			Value: value,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record compression: %w", err)
+	}
+	if r.JSON200 == nil {
+		return fmt.Errorf("failed to record compression: bad status code: %s, body: %s", r.Status(), string(r.Body))
+	}
+	return nil
+}
+
+// assetCompressOriginal returns the id of the original recorded by
+// AssetCompressRecord, empty for copies without a record
+func (c *ClientSimple) assetCompressOriginal(assetID types.UUID) (string, error) {
+	r, err := c.client.GetAssetMetadataWithResponse(c.ctx, assetID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get asset metadata: %w", err)
+	}
+	if r.JSON200 == nil {
+		return "", fmt.Errorf("failed to get asset metadata: bad status code: %s, body: %s", r.Status(), string(r.Body))
+	}
+	for _, item := range *r.JSON200 {
+		if item.Key != METADATA_COMPRESS {
+			continue
+		}
+		if original, ok := item.Value["original"].(string); ok {
+			return original, nil
+		}
+	}
+	return "", nil
+}
+
+// AssetIsCompressed reports whether asset is a copy uploaded by
+// immich-compress: it carries the __compressed__ tag or a record of
+// AssetCompressRecord. The motion video of a Live Photo may be an original
+// that was kept.
+func (c *ClientSimple) AssetIsCompressed(asset AssetResponseDto) (bool, error) {
+	if asset.GetTag(TAG_COMPRESSED) != "" {
+		return true, nil
+	}
+	if !asset.HasMetadata {
+		return false, nil
+	}
+	uuidAsset, err := UUUIDOfString(asset.Id)
+	if err != nil {
+		return false, err
+	}
+	original, err := c.assetCompressOriginal(uuidAsset)
+	return original != "", err
+}
@@ -0,0 +1,63 @@
+package immich
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAssetCompressRecord(t *testing.T) {
+	server := newFakeServer(t)
+	assetID := uuid.New()
+	originalID := uuid.NewString()
+	path := "/assets/" + assetID.String() + "/metadata"
+	server.JSON("PUT "+path, http.StatusOK, []AssetMetadataResponseDto{})
+
+	if err := server.client(t).AssetCompressRecord(assetID, originalID, "jxl-q75"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	requests := server.Received(http.MethodPut, path)
+	if len(requests) != 1 {
+		t.Fatalf("Expected one metadata upsert, got %d", len(requests))
+	}
+	var body AssetMetadataUpsertDto
+	if err := json.Unmarshal(requests[0].Body, &body); err != nil {
+		t.Fatalf("Invalid body %s: %v", requests[0].Body, err)
+	}
+	if len(body.Items) != 1 || body.Items[0].Key != METADATA_COMPRESS {
+		t.Fatalf("Expected one item below %s, got %+v", METADATA_COMPRESS, body.Items)
+	}
+	if value := body.Items[0].Value; value["quality"] != "jxl-q75" || value["original"] != originalID {
+		t.Errorf("Expected the quality and the original, got %v", value)
+	}
+	if len(server.Received(http.MethodPost, "/tags")) > 0 {
+		t.Error("Expected no tag to be created")
+	}
+}
+
+func TestAssetCompressRecordWithoutQuality(t *testing.T) {
+	server := newFakeServer(t)
+	assetID := uuid.New()
+	path := "/assets/" + assetID.String() + "/metadata"
+	server.JSON("PUT "+path, http.StatusOK, []AssetMetadataResponseDto{})
+
+	if err := server.client(t).AssetCompressRecord(assetID, uuid.NewString(), ""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var body AssetMetadataUpsertDto
+	if err := json.Unmarshal(server.Received(http.MethodPut, path)[0].Body, &body); err != nil {
+		t.Fatalf("Invalid body: %v", err)
+	}
+	if _, ok := body.Items[0].Value["quality"]; ok {
+		t.Errorf("Expected no quality, got %v", body.Items[0].Value)
+	}
+}
+
+func TestAssetCompressRecordFailed(t *testing.T) {
+	server := newFakeServer(t)
+	if err := server.client(t).AssetCompressRecord(uuid.New(), uuid.NewString(), "jxl-q75"); err == nil {
+		t.Error("Expected an error for a failed upsert")
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"time"
 )
 
+// GetTag returns the id of our tag tagName below __immich-compress__, empty
+// when the asset does not have it. A user tag of the same name elsewhere in
+// the tree does not count.
 func (a *AssetResponseDto) GetTag(tagName string) string {
 	value := ""
 	// check for nil before dereferencing!
 	if a.Tags != nil {
 		// Now it's safe to use *
 		for _, tag := range *a.Tags {
-			if tag.Name == tagName {
+			if tag.Value == TAG_ROOT+"/"+tagName {
 				value = tag.Id
 				return value
 			}
@@ -38,7 +38,7 @@ func TestAssetResponseDto_CompressedAfter(t *testing.T) {
 		{
 			name: "empty compressed_at tag value",
 			tags: &[]TagResponseDto{
-				{Name: TAG_COMPRESSED, Value: "", Id: ""},
+				{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: ""},
 			},
 			timestamp: baseTime,
 			expected:  false,
@@ -46,7 +46,7 @@ func TestAssetResponseDto_CompressedAfter(t *testing.T) {
 		{
 			name: "compressed after given timestamp",
 			tags: &[]TagResponseDto{
-				{Name: TAG_COMPRESSED, Value: "2024-01-01 14:00:00", Id: "2024-01-01 14:00:00"},
+				{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-01-01 14:00:00"},
 			},
 			timestamp: baseTime,
 			expected:  false,
@@ -54,7 +54,7 @@ func TestAssetResponseDto_CompressedAfter(t *testing.T) {
 		{
 			name: "compressed before given timestamp",
 			tags: &[]TagResponseDto{
-				{Name: TAG_COMPRESSED, Value: "2024-01-01 10:00:00", Id: "2024-01-01 10:00:00"},
+				{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-01-01 10:00:00"},
 			},
 			timestamp: baseTime,
 			expected:  false,
@@ -62,7 +62,7 @@ func TestAssetResponseDto_CompressedAfter(t *testing.T) {
 		{
 			name: "compressed exactly at given timestamp",
 			tags: &[]TagResponseDto{
-				{Name: TAG_COMPRESSED, Value: "2024-01-01 12:00:00", Id: "2024-01-01 12:00:00"},
+				{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-01-01 12:00:00"},
 			},
 			timestamp: baseTime,
 			expected:  false,
@@ -70,7 +70,7 @@ func TestAssetResponseDto_CompressedAfter(t *testing.T) {
 		{
 			name: "invalid timestamp format",
 			tags: &[]TagResponseDto{
-				{Name: TAG_COMPRESSED, Value: "invalid-timestamp", Id: "invalid-timestamp"},
+				{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "invalid-timestamp"},
 			},
 			timestamp: baseTime,
 			expected:  false,
@@ -79,16 +79,24 @@ func TestAssetResponseDto_CompressedAfter(t *testing.T) {
 			name: "multiple tags with compressed_at",
 			tags: &[]TagResponseDto{
 				{Name: "tag1", Value: "value1", Id: "value1"},
-				{Name: TAG_COMPRESSED, Value: "2024-01-01 14:00:00", Id: "2024-01-01 14:00:00"},
+				{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-01-01 14:00:00"},
 				{Name: "tag2", Value: "value2", Id: "value2"},
 			},
 			timestamp: baseTime,
 			expected:  false,
 		},
+		{
+			name: "user tag of the same name",
+			tags: &[]TagResponseDto{
+				{Name: TAG_COMPRESSED, Value: "Family/" + TAG_COMPRESSED, Id: "user"},
+			},
+			timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected:  false,
+		},
 		{
 			name: "future timestamp comparison",
 			tags: &[]TagResponseDto{
-				{Name: TAG_COMPRESSED, Value: "2024-01-01 10:00:00", Id: "2024-01-01 10:00:00"},
+				{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-01-01 10:00:00"},
 			},
 			timestamp: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
 			expected:  false,
@@ -117,7 +125,7 @@ func TestAssetResponseDto_CompressedAfter_EdgeCases(t *testing.T) {
 		leapDay := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC) // 2024 is a leap year
 
 		tags := &[]TagResponseDto{
-			{Name: TAG_COMPRESSED, Value: "2024-03-01 12:00:00", Id: "2024-03-01 12:00:00"},
+			{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-03-01 12:00:00"},
 		}
 
 		asset := &AssetResponseDto{Tags: tags, FileModifiedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
@@ -132,7 +140,7 @@ func TestAssetResponseDto_CompressedAfter_EdgeCases(t *testing.T) {
 		endOfYear := time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC)
 
 		tags := &[]TagResponseDto{
-			{Name: TAG_COMPRESSED, Value: "2024-01-01 00:00:00", Id: "2024-01-01 00:00:00"},
+			{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-01-01 00:00:00"},
 		}
 
 		asset := &AssetResponseDto{Tags: tags, FileModifiedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
@@ -148,7 +156,7 @@ func TestAssetResponseDto_CompressedAfter_EdgeCases(t *testing.T) {
 		estTime := time.Date(2024, 1, 1, 7, 0, 0, 0, time.FixedZone("EST", -5*60*60))
 
 		tags := &[]TagResponseDto{
-			{Name: TAG_COMPRESSED, Value: "2024-01-01 12:00:00", Id: "2024-01-01 12:00:00"},
+			{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED, Id: "2024-01-01 12:00:00"},
 		}
 
 		asset := &AssetResponseDto{Tags: tags}
@@ -192,10 +200,10 @@ func TestAssetResponseDto_GetTag(t *testing.T) {
 			expected: "",
 		},
 		{
-			name: "tag found should return its value",
+			name: "tag found should return its id",
 			asset: &AssetResponseDto{
 				Tags: &[]TagResponseDto{
-					{Name: "test-tag", Value: "test-value", Id: "test-value"},
+					{Name: "test-tag", Value: TAG_ROOT + "/test-tag", Id: "test-value"},
 				},
 			},
 			tagName:  "test-tag",
@@ -206,7 +214,7 @@ func TestAssetResponseDto_GetTag(t *testing.T) {
 			asset: &AssetResponseDto{
 				Tags: &[]TagResponseDto{
 					{Name: "tag1", Value: "value1", Id: "value1"},
-					{Name: "test-tag", Value: "target-value", Id: "target-value"},
+					{Name: "test-tag", Value: TAG_ROOT + "/test-tag", Id: "target-value"},
 					{Name: "tag2", Value: "value2", Id: "value2"},
 				},
 			},
@@ -217,7 +225,17 @@ func TestAssetResponseDto_GetTag(t *testing.T) {
 			name: "case sensitive tag name matching",
 			asset: &AssetResponseDto{
 				Tags: &[]TagResponseDto{
-					{Name: "Test-Tag", Value: "case-sensitive", Id: "case-sensitive"},
+					{Name: "Test-Tag", Value: TAG_ROOT + "/Test-Tag", Id: "case-sensitive"},
+				},
+			},
+			tagName:  "test-tag",
+			expected: "",
+		},
+		{
+			name: "user tag of the same name is ignored",
+			asset: &AssetResponseDto{
+				Tags: &[]TagResponseDto{
+					{Name: "test-tag", Value: "Family/test-tag", Id: "user"},
 				},
 			},
 			tagName:  "test-tag",
@@ -227,8 +245,8 @@ func TestAssetResponseDto_GetTag(t *testing.T) {
 			name: "duplicate tags should return first match",
 			asset: &AssetResponseDto{
 				Tags: &[]TagResponseDto{
-					{Name: "test-tag", Value: "first", Id: "first"},
-					{Name: "test-tag", Value: "second", Id: "second"},
+					{Name: "test-tag", Value: TAG_ROOT + "/test-tag", Id: "first"},
+					{Name: "test-tag", Value: TAG_ROOT + "/test-tag", Id: "second"},
 				},
 			},
 			tagName:  "test-tag",
@@ -272,7 +290,7 @@ func TestAssetResponseDto_GetTag_Performance(t *testing.T) {
 	targetIndex := numTags / 2
 	tags[targetIndex] = TagResponseDto{
 		Name:  TAG_COMPRESSED,
-		Value: "2024-01-01 12:00:00",
+		Value: TAG_ROOT + "/" + TAG_COMPRESSED,
 		Id:    "2024-01-01 12:00:00",
 	}
 
@@ -329,3 +347,25 @@ func TestAssetResponseDto_NilPointerSafety(t *testing.T) {
 		}
 	})
 }
+
+func TestTagResponseDto_IsOwn(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      TagResponseDto
+		expected bool
+	}{
+		{name: "root tag", tag: TagResponseDto{Name: TAG_ROOT, Value: TAG_ROOT}, expected: true},
+		{name: "compressed tag", tag: TagResponseDto{Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED}, expected: true},
+		{name: "run tag", tag: TagResponseDto{Name: "20240101-120000", Value: TAG_ROOT + "/" + TAG_RUNS + "/20240101-120000"}, expected: true},
+		{name: "user tag", tag: TagResponseDto{Name: "holiday", Value: "holiday"}, expected: false},
+		{name: "similar prefix", tag: TagResponseDto{Name: "x", Value: TAG_ROOT + "-other/x"}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.tag.IsOwn(); result != tt.expected {
+				t.Errorf("IsOwn() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
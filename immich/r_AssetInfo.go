@@ -0,0 +1,20 @@
+package immich
+
+import (
+	"fmt"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+// AssetInfo fetches a single asset with its exif info
+func (c *ClientSimple) AssetInfo(assetID openapi_types.UUID) (*AssetResponseDto, error) {
+	resp, err := c.client.GetAssetInfoWithResponse(c.ctx, assetID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get asset info: %w", err)
+	}
+	if resp.JSON200 == nil {
+		return nil, fmt.Errorf("asset info request failed with status %d: %s", resp.StatusCode(), resp.Status())
+	}
+
+	return resp.JSON200, nil
+}
@@ -0,0 +1,91 @@
+package immich
+
+import (
+	"fmt"
+	"net/http"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+// AssetRestore moves assets back out of the trash
+func (c *ClientSimple) AssetRestore(assetIDs []openapi_types.UUID) error {
+	resp, err := c.client.RestoreAssetsWithResponse(retrySafe(c.ctx), BulkIdsDto{Ids: assetIDs})
+	if err != nil {
+		return fmt.Errorf("failed to restore assets: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("restore request failed with status %d: %s", resp.StatusCode(), resp.Status())
+	}
+
+	return nil
+}
+
+// AssetFindTrashedOriginal looks in the trash for the asset that a compressed
+// copy replaced. The original recorded by AssetCompressRecord is preferred,
+// a copy that reused a duplicate has the device ids of that duplicate.
+// Copies without a record are uploaded with the device asset id and device
+// id of the original, so those identify it. If an asset was compressed
+// several times the most recently trashed one is the direct original.
+func (c *ClientSimple) AssetFindTrashedOriginal(compressed AssetResponseDto) (*AssetResponseDto, error) {
+	originalID := ""
+	if compressed.HasMetadata {
+		uuidCompressed, err := UUUIDOfString(compressed.Id)
+		if err != nil {
+			return nil, err
+		}
+		originalID, err = c.assetCompressOriginal(uuidCompressed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if originalID != "" {
+		uuidOrig, err := UUUIDOfString(originalID)
+		if err != nil {
+			return nil, err
+		}
+		original, err := c.AssetInfo(uuidOrig)
+		if err != nil {
+			return nil, fmt.Errorf("can not get original of '%s': %w", compressed.OriginalFileName, err)
+		}
+		if !original.IsTrashed {
+			return nil, fmt.Errorf("original of '%s' is not in the trash", compressed.OriginalFileName)
+		}
+		return original, nil
+	}
+
+	withDeleted := true
+	var page float32 = 1
+	search := SearchAssetsJSONRequestBody{
+		DeviceAssetId: &compressed.DeviceAssetId,
+		DeviceId:      &compressed.DeviceId,
+		WithDeleted:   &withDeleted,
+		Page:          &page,
+	}
+
+	var original *AssetResponseDto
+	for {
+		resp, nextPage, err := c.getAssets(search)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			break
+		}
+		for _, item := range resp.JSON200.Assets.Items {
+			// The motion video of a Live Photo shares the device asset id
+			if !item.IsTrashed || item.Id == compressed.Id || item.Type != compressed.Type {
+				continue
+			}
+			if original == nil || item.UpdatedAt.After(original.UpdatedAt) {
+				original = &item
+			}
+		}
+		search.Page = &nextPage
+	}
+
+	if original == nil {
+		return nil, fmt.Errorf("no original in trash for '%s'", compressed.OriginalFileName)
+	}
+
+	return original, nil
+}
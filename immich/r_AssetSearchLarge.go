@@ -0,0 +1,123 @@
+package immich
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// LargeAssetsMax is the most assets the server returns for one large assets
+// search
+const LargeAssetsMax = 1000
+
+// AssetSearchLarge streams the assets of at least minSize bytes, largest
+// first. The large assets search has no paging, so at most count assets, or
+// LargeAssetsMax when count is 0, are returned.
+func (c *ClientSimple) AssetSearchLarge(limit int, count int, minSize int64, search SearchAssetsJSONRequestBody) <-chan struct {
+	Asset AssetResponseDto
+	Err   error
+} {
+	ch := make(chan struct {
+		Asset AssetResponseDto
+		Err   error
+	}, c.parallel)
+	go func() {
+		defer close(ch)
+		assets, err := c.searchLarge(count, minSize, search)
+		if err != nil {
+			ch <- struct {
+				Asset AssetResponseDto
+				Err   error
+			}{Asset: AssetResponseDto{}, Err: err}
+			return
+		}
+
+		processedCount := 0
+		for _, item := range assets {
+			// Check if limit is reached (limit 0 means no limit)
+			if limit > 0 && processedCount >= limit {
+				return
+			}
+			select {
+			case <-c.ctx.Done():
+				return
+			case ch <- struct {
+				Asset AssetResponseDto
+				Err   error
+			}{Asset: item, Err: nil}:
+				processedCount++
+			}
+		}
+	}()
+
+	return ch
+}
+
+func (c *ClientSimple) searchLarge(count int, minSize int64, search SearchAssetsJSONRequestBody) ([]AssetResponseDto, error) {
+	if count <= 0 || count > LargeAssetsMax {
+		count = LargeAssetsMax
+	}
+	params := largeAssetsParams(search)
+	size := float32(count)
+	params.Size = &size
+	if minSize > 0 {
+		minFileSize := int(minSize)
+		params.MinFileSize = &minFileSize
+	}
+
+	r, err := c.client.SearchLargeAssetsWithResponse(c.ctx, &params)
+	if err != nil {
+		return nil, fmt.Errorf("error getting large assets: %w", err)
+	}
+	if r.JSON200 == nil {
+		return nil, fmt.Errorf("bad status code: %s, body: %s", r.Status(), string(r.Body))
+	}
+	if len(*r.JSON200) == LargeAssetsMax {
+		fmt.Printf("Only the %d largest assets are processed, run again for the rest\n", LargeAssetsMax)
+	}
+
+	assets := make([]AssetResponseDto, 0, len(*r.JSON200))
+	for _, item := range *r.JSON200 {
+		if item.IsTrashed || item.ExifInfo == nil || item.ExifInfo.FileSizeInByte == nil {
+			continue
+		}
+		// The large assets search does not filter by file name
+		if search.OriginalFileName != nil && !strings.Contains(strings.ToLower(item.OriginalFileName), strings.ToLower(*search.OriginalFileName)) {
+			continue
+		}
+		assets = append(assets, item)
+	}
+	slices.SortStableFunc(assets, func(a, b AssetResponseDto) int {
+		return cmp.Compare(*b.ExifInfo.FileSizeInByte, *a.ExifInfo.FileSizeInByte)
+	})
+
+	return assets, nil
+}
+
+// largeAssetsParams carries the filters of a metadata search over to the
+// large assets search
+func largeAssetsParams(search SearchAssetsJSONRequestBody) SearchLargeAssetsParams {
+	withExif := true
+	return SearchLargeAssetsParams{
+		AlbumIds:      search.AlbumIds,
+		City:          search.City,
+		Country:       search.Country,
+		CreatedAfter:  search.CreatedAfter,
+		CreatedBefore: search.CreatedBefore,
+		DeviceId:      search.DeviceId,
+		IsFavorite:    search.IsFavorite,
+		IsNotInAlbum:  search.IsNotInAlbum,
+		LensModel:     search.LensModel,
+		Make:          search.Make,
+		Model:         search.Model,
+		PersonIds:     search.PersonIds,
+		State:         search.State,
+		TagIds:        search.TagIds,
+		TakenAfter:    search.TakenAfter,
+		TakenBefore:   search.TakenBefore,
+		Type:          search.Type,
+		Visibility:    search.Visibility,
+		WithExif:      &withExif,
+	}
+}
@@ -0,0 +1,32 @@
+package immich
+
+import (
+	"testing"
+)
+
+func TestLargeAssetsParams(t *testing.T) {
+	city := "Berlin"
+	typeAsset := VIDEO
+	isFavorite := false
+	params := largeAssetsParams(SearchAssetsJSONRequestBody{
+		City:       &city,
+		Type:       &typeAsset,
+		IsFavorite: &isFavorite,
+	})
+
+	if params.City == nil || *params.City != city {
+		t.Errorf("Expected city %s, got %v", city, params.City)
+	}
+	if params.Type == nil || *params.Type != VIDEO {
+		t.Errorf("Expected type VIDEO, got %v", params.Type)
+	}
+	if params.IsFavorite == nil || *params.IsFavorite {
+		t.Errorf("Expected favorites to be excluded, got %v", params.IsFavorite)
+	}
+	if params.WithExif == nil || !*params.WithExif {
+		t.Error("Expected exif to be requested, the file size is needed")
+	}
+	if params.Make != nil {
+		t.Errorf("Expected no make, got %v", *params.Make)
+	}
+}
@@ -1,11 +1,12 @@
 package immich
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,12 +16,44 @@ import (
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
 
+// AssetUploadCopy uploads file as the copy of asset and copies the relations
+// of asset to it. When the library holds a file with the same checksum
+// already, that asset is used instead of uploading the file twice.
 func (c *ClientSimple) AssetUploadCopy(asset AssetResponseDto, file *os.File) (*openapi_types.UUID, error) {
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		return nil, err
+	}
+	var uuidNew openapi_types.UUID
+	duplicate, err := c.AssetFindByChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+	if duplicate != nil {
+		uuidNew, err = c.reuseDuplicate(asset, *duplicate)
+	} else {
+		uuidNew, err = c.assetUpload(asset, file, checksum)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.AssetCopyRelations(asset, uuidNew)
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
+
+	return &uuidNew, nil
+}
+
+// assetUpload uploads file with the dates, device and metadata of asset
+func (c *ClientSimple) assetUpload(asset AssetResponseDto, file *os.File, checksum string) (openapi_types.UUID, error) {
+	var uuidNew openapi_types.UUID
 	origNameWithoutExt := strings.TrimSuffix(asset.OriginalFileName, filepath.Ext(asset.OriginalFileName))
 
 	uuidOrig, err := uuid.Parse(asset.Id)
 	if err != nil {
-		return nil, err
+		return uuidNew, err
 	}
 	// Get metadata from asset if it exists
 	var metadata []AssetMetadataUpsertItemDto
@@ -28,6 +61,11 @@ func (c *ClientSimple) AssetUploadCopy(asset AssetResponseDto, file *os.File) (*
 		resp, err := c.client.GetAssetMetadataWithResponse(c.ctx, uuidOrig)
 		if err == nil && resp.JSON200 != nil {
 			for _, item := range *resp.JSON200 {
+				// The quality of an earlier compression does not describe
+				// the new file
+				if item.Key == METADATA_COMPRESS {
+					continue
+				}
 				metadata = append(metadata, AssetMetadataUpsertItemDto{
 					Key:   item.Key,
 					Value: item.Value,
@@ -36,34 +74,72 @@ func (c *ClientSimple) AssetUploadCopy(asset AssetResponseDto, file *os.File) (*
 		}
 	}
 	params := &uploadAssetBody{
-		DeviceAssetID:    asset.DeviceAssetId,
-		DeviceID:         asset.DeviceId,
-		Duration:         asset.Duration,
-		FileCreatedAt:    asset.FileCreatedAt,
-		FileModifiedAt:   time.Now(),
-		Filename:         origNameWithoutExt + filepath.Ext(file.Name()),
-		IsFavorite:       asset.IsFavorite,
-		Metadata:         metadata,
-		Visibility:       string(asset.Visibility),
-		LivePhotoVideoID: *asset.LivePhotoVideoId,
+		DeviceAssetID:  asset.DeviceAssetId,
+		DeviceID:       asset.DeviceId,
+		Duration:       asset.Duration,
+		FileCreatedAt:  asset.FileCreatedAt,
+		FileModifiedAt: time.Now(),
+		Filename:       origNameWithoutExt + filepath.Ext(file.Name()),
+		IsFavorite:     asset.IsFavorite,
+		Metadata:       metadata,
+		Visibility:     string(asset.Visibility),
+	}
+	// A still of a Live Photo is linked to its motion video
+	if asset.LivePhotoVideoId != nil {
+		params.LivePhotoVideoID = *asset.LivePhotoVideoId
 	}
 
 	// 3. Create the multipart body and content type
-	body, contentType, err := assetUploadMultipartBody(params, file)
+	openBody, contentType, err := assetUploadMultipartBody(params, file)
 	if err != nil {
-		return nil, err
+		return uuidNew, err
 	}
+	body := openBody()
+	// Stops the writer when the request ends before reading the whole body
+	defer body.Close()
 
-	// Stream the file directly without loading into memory
-	rUp, err := c.client.UploadAssetWithBodyWithResponse(c.ctx, &UploadAssetParams{}, contentType, body)
+	// The checksum header lets the server reject a duplicate before the body.
+	// It also answers a repeated upload with the asset stored before, so a
+	// failed upload is retried with a new body.
+	rUp, err := c.client.UploadAssetWithBodyWithResponse(retrySafe(c.ctx), &UploadAssetParams{XImmichChecksum: &checksum}, contentType, body,
+		func(ctx context.Context, req *http.Request) error {
+			req.GetBody = func() (io.ReadCloser, error) {
+				return openBody(), nil
+			}
+			return nil
+		})
 	if err != nil {
-		return nil, fmt.Errorf("upload failed: %w", err)
+		return uuidNew, fmt.Errorf("upload failed: %w", err)
+	}
+	uploaded, err := uploadedAsset(rUp)
+	if err != nil {
+		return uuidNew, fmt.Errorf("upload failed: %w", err)
 	}
 
-	uuidNew, err := uuid.Parse(rUp.JSON201.Id)
+	return UUUIDOfString(uploaded.Id)
+}
+
+// AssetCopyRelations copies albums, favorite, shared links, sidecar, stack
+// and tags of the source asset to the target. Our own tags below
+// __immich-compress__ are not copied, they describe the source only. When
+// the source is the primary of its stack the target takes its place.
+func (c *ClientSimple) AssetCopyRelations(source AssetResponseDto, targetID openapi_types.UUID) error {
+	sourceID, err := uuid.Parse(source.Id)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// Immich merges the stacks when the target is stacked already, which
+	// deletes the stack if both are the same
+	copyStack := source.Stack != nil
+	if copyStack {
+		target, err := c.AssetInfo(targetID)
+		if err != nil {
+			return err
+		}
+		copyStack = target.Stack == nil || target.Stack.Id != source.Stack.Id
 	}
+
 	t := true
 	// copy asset with API
 	_, err = c.client.CopyAssetWithResponse(c.ctx, CopyAssetJSONRequestBody{
@@ -71,34 +147,46 @@ func (c *ClientSimple) AssetUploadCopy(asset AssetResponseDto, file *os.File) (*
 		Favorite:    &t,
 		SharedLinks: &t,
 		Sidecar:     &t,
-		SourceId:    uuidOrig,
-		Stack:       new(bool),
-		TargetId:    uuidNew,
+		SourceId:    sourceID,
+		Stack:       &copyStack,
+		TargetId:    targetID,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("upload failed: %w", err)
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	if source.Stack != nil && source.Stack.PrimaryAssetId == source.Id {
+		err = c.StackPrimarySet(source.Stack.Id, targetID)
+		if err != nil {
+			return err
+		}
 	}
+
 	// Copy tags from old asset to new one
-	if asset.Tags != nil && len(*asset.Tags) > 0 {
-		tagIds := make([]openapi_types.UUID, 0, len(*asset.Tags))
-		for _, tag := range *asset.Tags {
+	if source.Tags != nil && len(*source.Tags) > 0 {
+		tagIds := make([]openapi_types.UUID, 0, len(*source.Tags))
+		for _, tag := range *source.Tags {
+			if tag.IsOwn() {
+				continue
+			}
 			tagUUID, err := uuid.Parse(tag.Id)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse tag UUID '%s': %w", tag.Id, err)
+				return fmt.Errorf("failed to parse tag UUID '%s': %w", tag.Id, err)
 			}
 			tagIds = append(tagIds, tagUUID)
 		}
-
+		if len(tagIds) == 0 {
+			return nil
+		}
 		_, err = c.client.BulkTagAssetsWithResponse(c.ctx, TagBulkAssetsDto{
-			AssetIds: []openapi_types.UUID{uuidNew},
+			AssetIds: []openapi_types.UUID{targetID},
 			TagIds:   tagIds,
 		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to copy tags: %w", err)
+			return fmt.Errorf("failed to copy tags: %w", err)
 		}
 	}
 
-	return &uuidNew, nil
+	return nil
 }
 
 type uploadAssetBody struct {
@@ -116,65 +204,90 @@ type uploadAssetBody struct {
 	Visibility string `json:"visibility"`
 }
 
-// createMultipartBody is the core logic
-func assetUploadMultipartBody(params *uploadAssetBody, assetFile *os.File) (io.Reader, string, error) {
-	// Create a new buffer to write the multipart body to
-	bodyBuf := &bytes.Buffer{}
-	// Create a new multipart writer
-	writer := multipart.NewWriter(bodyBuf)
+// assetUploadMultipartBody returns a function that streams the multipart
+// body of an upload. The body is written by a goroutine into a pipe while
+// the request reads it, so only a small buffer is held in memory whatever
+// the size of the file. Closing the returned reader stops the goroutine.
+// Every body reads the file from its start with the same boundary, so it can
+// be opened again for a retry.
+func assetUploadMultipartBody(params *uploadAssetBody, assetFile *os.File) (func() io.ReadCloser, string, error) {
+	// Marshal the metadata first, errors after the request started can
+	// only abort it
+	metaJSON, err := json.Marshal(params.Metadata)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	info, err := assetFile.Stat()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to stat '%s': %w", assetFile.Name(), err)
+	}
+	first := multipart.NewWriter(io.Discard)
+	boundary := first.Boundary()
 
+	openBody := func() io.ReadCloser {
+		bodyReader, bodyWriter := io.Pipe()
+		go func() {
+			writer := multipart.NewWriter(bodyWriter)
+			err := writer.SetBoundary(boundary)
+			if err == nil {
+				// A section reader keeps its own offset, an aborted body
+				// still reading does not disturb the next one
+				err = writeAssetUploadMultipart(writer, params, io.NewSectionReader(assetFile, 0, info.Size()), metaJSON)
+			}
+			bodyWriter.CloseWithError(err)
+		}()
+		return bodyReader
+	}
+	return openBody, first.FormDataContentType(), nil
+}
+
+// writeAssetUploadMultipart writes the parts of an upload and the final
+// boundary
+func writeAssetUploadMultipart(writer *multipart.Writer, params *uploadAssetBody, assetFile io.Reader, metaJSON []byte) error {
 	// --- 1. Stream the 'assetData' file ---
 	// CreateFormFile returns an io.Writer for the file part
 	part, err := writer.CreateFormFile("assetData", params.Filename)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create form file: %w", err)
+		return fmt.Errorf("failed to create form file: %w", err)
 	}
-	// Stream the file's content directly into the multipart part
-	// This avoids loading the entire file into memory
+	// io.Copy moves the file through a fixed size buffer into the pipe
 	_, err = io.Copy(part, assetFile)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to copy file to multipart: %w", err)
+		return fmt.Errorf("failed to copy file to multipart: %w", err)
 	}
 
 	// --- 2. Add all other metadata fields ---
-
-	// Simple string fields
-	_ = writer.WriteField("deviceAssetId", params.DeviceAssetID)
-	_ = writer.WriteField("deviceId", params.DeviceID)
-	_ = writer.WriteField("duration", params.Duration)
-	_ = writer.WriteField("filename", params.Filename)
-	_ = writer.WriteField("livePhotoVideoId", params.LivePhotoVideoID)
-	_ = writer.WriteField("visibility", params.Visibility)
-
-	// Boolean field
-	_ = writer.WriteField("isFavorite", fmt.Sprintf("%t", params.IsFavorite))
-
-	// DateTime fields (format as ISO 8601 string)
-	_ = writer.WriteField("fileCreatedAt", params.FileCreatedAt.Format(time.RFC3339))
-	_ = writer.WriteField("fileModifiedAt", params.FileModifiedAt.Format(time.RFC3339))
-
-	// --- 3. Add the 'metadata' (JSON array) ---
-	// Marshal the metadata struct to a JSON string
-	metaJSON, err := json.Marshal(params.Metadata)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to marshal metadata: %w", err)
+	fields := [][2]string{
+		{"deviceAssetId", params.DeviceAssetID},
+		{"deviceId", params.DeviceID},
+		{"duration", params.Duration},
+		{"filename", params.Filename},
+	}
+	if params.LivePhotoVideoID != "" {
+		fields = append(fields, [2]string{"livePhotoVideoId", params.LivePhotoVideoID})
+	}
+	fields = append(fields,
+		[2]string{"visibility", params.Visibility},
+		[2]string{"isFavorite", fmt.Sprintf("%t", params.IsFavorite)},
+		// DateTime fields (format as ISO 8601 string)
+		[2]string{"fileCreatedAt", params.FileCreatedAt.Format(time.RFC3339)},
+		[2]string{"fileModifiedAt", params.FileModifiedAt.Format(time.RFC3339)},
+		// --- 3. Add the 'metadata' (JSON array) ---
+		[2]string{"metadata", string(metaJSON)},
+	)
+	for _, field := range fields {
+		// A failed write means the request is gone, stop at the first one
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return fmt.Errorf("failed to write field '%s': %w", field[0], err)
+		}
 	}
-	_ = writer.WriteField("metadata", string(metaJSON))
-
-	// --- 4. Add 'sidecarData' (if you have it) ---
-	// If you had sidecar data (e.g., from another file), you would
-	// use CreateFormFile again, just like with assetData.
-	// Example:
-	// sidecarPart, err := writer.CreateFormFile("sidecarData", "my-sidecar.xmp")
-	// _, err = io.Copy(sidecarPart, sidecarFileReader)
 
-	// --- 5. Finalize ---
+	// --- 4. Finalize ---
 	// Close the writer to write the final boundary
 	err = writer.Close()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to close multipart writer: %w", err)
+		return fmt.Errorf("failed to close multipart writer: %w", err)
 	}
-
-	// Return the buffer (which is an io.Reader) and the content type
-	return bodyBuf, writer.FormDataContentType(), nil
+	return nil
 }
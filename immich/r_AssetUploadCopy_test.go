@@ -0,0 +1,176 @@
+package immich
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// readMultipartFields returns the non-file fields of a multipart body
+func readMultipartFields(t *testing.T, body io.Reader, contentType string) map[string]string {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Invalid content type %q: %v", contentType, err)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		if part.FileName() == "" {
+			fields[part.FormName()] = string(value)
+		}
+	}
+	return fields
+}
+
+func TestAssetUploadMultipartBodyLivePhoto(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "still.jxl"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	t.Run("without motion video", func(t *testing.T) {
+		openBody, contentType, err := assetUploadMultipartBody(&uploadAssetBody{Filename: "still.jxl"}, file)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		fields := readMultipartFields(t, openBody(), contentType)
+		if _, ok := fields["livePhotoVideoId"]; ok {
+			t.Error("Expected no livePhotoVideoId for a plain photo")
+		}
+	})
+
+	t.Run("with motion video", func(t *testing.T) {
+		params := &uploadAssetBody{
+			Filename:         "still.jxl",
+			LivePhotoVideoID: "0f8fad5b-d9cb-469f-a165-70867728950e",
+		}
+		openBody, contentType, err := assetUploadMultipartBody(params, file)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		fields := readMultipartFields(t, openBody(), contentType)
+		if fields["livePhotoVideoId"] != params.LivePhotoVideoID {
+			t.Errorf("Expected livePhotoVideoId %s, got %q", params.LivePhotoVideoID, fields["livePhotoVideoId"])
+		}
+	})
+}
+
+func TestAssetUploadMultipartBodyStreamsFile(t *testing.T) {
+	content := strings.Repeat("compressed video data ", 100_000)
+	file, err := os.Create(filepath.Join(t.TempDir(), "video.mkv"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Failed to rewind file: %v", err)
+	}
+
+	openBody, contentType, err := assetUploadMultipartBody(&uploadAssetBody{Filename: "video.mkv", DeviceID: "phone"}, file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body := openBody()
+	defer body.Close()
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Invalid content type %q: %v", contentType, err)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatalf("Failed to read part: %v", err)
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatalf("Failed to read part: %v", err)
+	}
+	if part.FormName() != "assetData" || part.FileName() != "video.mkv" || string(data) != content {
+		t.Errorf("Expected the file as first part, got %s %s with %d bytes", part.FormName(), part.FileName(), len(data))
+	}
+}
+
+func TestAssetCopyRelationsStack(t *testing.T) {
+	stackID := uuid.NewString()
+	tests := []struct {
+		name        string
+		primary     bool
+		targetStack *AssetStackResponseDto
+		copyStack   bool
+	}{
+		{name: "primary", primary: true, copyStack: true},
+		{name: "not primary", copyStack: true},
+		// Copying into the same stack would merge it with itself and delete it
+		{name: "target in the same stack", targetStack: &AssetStackResponseDto{Id: stackID, AssetCount: 3}, copyStack: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := AssetResponseDto{Id: uuid.NewString(), Stack: &AssetStackResponseDto{Id: stackID, AssetCount: 2, PrimaryAssetId: uuid.NewString()}}
+			if tt.primary {
+				source.Stack.PrimaryAssetId = source.Id
+			}
+			targetID := uuid.New()
+
+			server := newFakeServer(t)
+			server.JSON("GET /assets/"+targetID.String(), http.StatusOK, AssetResponseDto{Id: targetID.String(), Stack: tt.targetStack})
+			server.Handle("PUT /assets/copy", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			server.JSON("PUT /stacks/"+stackID, http.StatusOK, StackResponseDto{Id: stackID, PrimaryAssetId: targetID.String()})
+
+			if err := server.client(t).AssetCopyRelations(source, targetID); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			copies := server.Received(http.MethodPut, "/assets/copy")
+			if len(copies) != 1 {
+				t.Fatalf("Expected one copy, got %d", len(copies))
+			}
+			var body AssetCopyDto
+			if err := json.Unmarshal(copies[0].Body, &body); err != nil {
+				t.Fatalf("Invalid body %s: %v", copies[0].Body, err)
+			}
+			if body.Stack == nil || *body.Stack != tt.copyStack {
+				t.Errorf("Expected stack %v in the copy, got %s", tt.copyStack, copies[0].Body)
+			}
+
+			updates := server.Received(http.MethodPut, "/stacks/"+stackID)
+			if !tt.primary {
+				if len(updates) > 0 {
+					t.Errorf("Expected the primary to stay, got %d stack updates", len(updates))
+				}
+				return
+			}
+			var update StackUpdateDto
+			if len(updates) != 1 || json.Unmarshal(updates[0].Body, &update) != nil || update.PrimaryAssetId == nil || *update.PrimaryAssetId != targetID {
+				t.Errorf("Expected the target to become the primary, got %+v", updates)
+			}
+		})
+	}
+}
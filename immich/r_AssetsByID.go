@@ -0,0 +1,100 @@
+package immich
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// AssetError is the failure of a single asset of a stream, the other assets
+// can still be processed
+type AssetError struct {
+	AssetID string
+	Err     error
+}
+
+func (e *AssetError) Error() string {
+	return fmt.Sprintf("asset %s: %v", e.AssetID, e.Err)
+}
+
+func (e *AssetError) Unwrap() error {
+	return e.Err
+}
+
+// AssetsByID streams the assets with the given ids, fetched with up to
+// parallel requests at a time instead of searching the whole library.
+// Trashed assets are left out, the order is not kept. An asset that can not
+// be fetched is sent as an *AssetError with its id.
+func (c *ClientSimple) AssetsByID(limit int, assetIDs []types.UUID) <-chan struct {
+	Asset AssetResponseDto
+	Err   error
+} {
+	ch := make(chan struct {
+		Asset AssetResponseDto
+		Err   error
+	}, c.parallel)
+	ids := make(chan types.UUID)
+	go func() {
+		defer close(ids)
+		for _, id := range assetIDs {
+			select {
+			case <-c.ctx.Done():
+				return
+			case ids <- id:
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	processedCount := 0
+	var wg sync.WaitGroup
+	for range max(c.parallel, 1) {
+		wg.Go(func() {
+			for id := range ids {
+				mu.Lock()
+				done := limit > 0 && processedCount >= limit
+				mu.Unlock()
+				if done {
+					continue
+				}
+
+				asset, err := c.AssetInfo(id)
+				if err == nil && asset.IsTrashed {
+					continue
+				}
+
+				// Check if limit is reached (limit 0 means no limit)
+				mu.Lock()
+				if limit > 0 && processedCount >= limit {
+					mu.Unlock()
+					continue
+				}
+				processedCount++
+				mu.Unlock()
+
+				item := struct {
+					Asset AssetResponseDto
+					Err   error
+				}{}
+				if err == nil {
+					item.Asset = *asset
+				} else {
+					item.Asset.Id = id.String()
+					item.Err = &AssetError{AssetID: id.String(), Err: err}
+				}
+				select {
+				case <-c.ctx.Done():
+					return
+				case ch <- item:
+				}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	return ch
+}
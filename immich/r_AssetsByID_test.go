@@ -0,0 +1,103 @@
+package immich
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// assetsServer answers the asset infos of ids and counts the requests in
+// flight
+func assetsServer(t *testing.T, assets map[types.UUID]AssetResponseDto) (*fakeServer, *atomic.Int32) {
+	t.Helper()
+	server := newFakeServer(t)
+	var inFlight, maxInFlight atomic.Int32
+	for id, asset := range assets {
+		server.Handle("GET /assets/"+id.String(), func(w http.ResponseWriter, r *http.Request) {
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				seen := maxInFlight.Load()
+				if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(asset)
+		})
+	}
+	return server, &maxInFlight
+}
+
+func TestAssetsByID(t *testing.T) {
+	assets := map[types.UUID]AssetResponseDto{}
+	var ids []types.UUID
+	for range 8 {
+		id := uuid.New()
+		assets[id] = AssetResponseDto{Id: id.String()}
+		ids = append(ids, id)
+	}
+	trashed := uuid.New()
+	assets[trashed] = AssetResponseDto{Id: trashed.String(), IsTrashed: true}
+	ids = append(ids, trashed)
+	missing := uuid.New()
+	ids = append(ids, missing)
+
+	server, maxInFlight := assetsServer(t, assets)
+	client := server.client(t)
+
+	found := map[string]bool{}
+	failed := 0
+	for item := range client.AssetsByID(0, ids) {
+		if item.Err != nil {
+			var assetErr *AssetError
+			if !errors.As(item.Err, &assetErr) || assetErr.AssetID != missing.String() || item.Asset.Id != missing.String() {
+				t.Errorf("Expected the error of the missing asset, got %v", item.Err)
+			}
+			failed++
+			continue
+		}
+		found[item.Asset.Id] = true
+	}
+	if len(found) != 8 || found[trashed.String()] {
+		t.Errorf("Expected the 8 assets without the trashed one, got %v", found)
+	}
+	if failed != 1 {
+		t.Errorf("Expected the missing asset as an error, got %d errors", failed)
+	}
+	if maxInFlight.Load() > int32(client.parallel) {
+		t.Errorf("Expected at most %d requests at a time, got %d", client.parallel, maxInFlight.Load())
+	}
+}
+
+func TestAssetsByIDLimit(t *testing.T) {
+	assets := map[types.UUID]AssetResponseDto{}
+	var ids []types.UUID
+	for range 6 {
+		id := uuid.New()
+		assets[id] = AssetResponseDto{Id: id.String()}
+		ids = append(ids, id)
+	}
+	server, _ := assetsServer(t, assets)
+
+	count := 0
+	for item := range server.client(t).AssetsByID(3, ids) {
+		if item.Err != nil {
+			t.Fatalf("Unexpected error: %v", item.Err)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("Expected the limit of 3 assets, got %d", count)
+	}
+	if requests := len(server.All()); requests > 3+server.client(t).parallel {
+		t.Errorf("Expected the fetching to stop near the limit, got %d requests", requests)
+	}
+}
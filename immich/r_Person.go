@@ -0,0 +1,37 @@
+package immich
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// PersonFind returns the id of the person with this name, ignoring case
+func (c *ClientSimple) PersonFind(name string) (types.UUID, error) {
+	var uuid types.UUID
+	withHidden := true
+	resp, err := c.client.SearchPersonWithResponse(c.ctx, &SearchPersonParams{Name: name, WithHidden: &withHidden})
+	if err != nil {
+		return uuid, fmt.Errorf("failed to search person: %w", err)
+	}
+	if resp.JSON200 == nil {
+		return uuid, fmt.Errorf("person search failed with status %d: %s", resp.StatusCode(), resp.Status())
+	}
+
+	// The search matches prefixes, only the full name counts
+	var found []string
+	for _, person := range *resp.JSON200 {
+		if strings.EqualFold(person.Name, name) {
+			found = append(found, person.Id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return uuid, fmt.Errorf("person '%s' not found", name)
+	case 1:
+		return UUUIDOfString(found[0])
+	default:
+		return uuid, fmt.Errorf("person name '%s' is ambiguous, %d people have it", name, len(found))
+	}
+}
@@ -0,0 +1,77 @@
+package immich
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/oapi-codegen/runtime/types"
+)
+
+// StacksLoad indexes all stacks by the ids of their assets, so the stack of
+// an asset is known without a request per asset
+func (c *ClientSimple) StacksLoad() error {
+	resp, err := c.client.SearchStacksWithResponse(c.ctx, &SearchStacksParams{})
+	if err != nil {
+		return fmt.Errorf("failed to get stacks: %w", err)
+	}
+	if resp.JSON200 == nil {
+		return fmt.Errorf("stacks request failed with status %d: %s", resp.StatusCode(), resp.Status())
+	}
+
+	byAsset := map[string]*AssetStackResponseDto{}
+	for _, stack := range *resp.JSON200 {
+		// Members share the entry, a new primary is seen by all of them
+		entry := &AssetStackResponseDto{
+			AssetCount:     len(stack.Assets),
+			Id:             stack.Id,
+			PrimaryAssetId: stack.PrimaryAssetId,
+		}
+		for _, asset := range stack.Assets {
+			byAsset[asset.Id] = entry
+		}
+	}
+
+	c.stacks.mu.Lock()
+	defer c.stacks.mu.Unlock()
+	c.stacks.byAsset = byAsset
+	return nil
+}
+
+// StackOf returns the stack of an asset from the index, nil when it is not
+// stacked
+func (c *ClientSimple) StackOf(assetID string) *AssetStackResponseDto {
+	c.stacks.mu.Lock()
+	defer c.stacks.mu.Unlock()
+	entry, ok := c.stacks.byAsset[assetID]
+	if !ok {
+		return nil
+	}
+	stack := *entry
+	return &stack
+}
+
+// StackPrimarySet makes an asset the primary of a stack
+func (c *ClientSimple) StackPrimarySet(stackID string, assetID types.UUID) error {
+	uuidStack, err := UUUIDOfString(stackID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.UpdateStackWithResponse(c.ctx, uuidStack, StackUpdateDto{PrimaryAssetId: &assetID})
+	if err != nil {
+		return fmt.Errorf("failed to update stack: %w", err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("stack update failed with status %d: %s", resp.StatusCode(), resp.Status())
+	}
+
+	c.stacks.mu.Lock()
+	defer c.stacks.mu.Unlock()
+	for _, entry := range c.stacks.byAsset {
+		if entry.Id == stackID {
+			entry.PrimaryAssetId = assetID.String()
+			c.stacks.byAsset[assetID.String()] = entry
+			break
+		}
+	}
+	return nil
+}
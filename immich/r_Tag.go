@@ -2,6 +2,7 @@ package immich
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/oapi-codegen/runtime/types"
 )
@@ -9,12 +10,23 @@ import (
 const (
 	TAG_ROOT       = "__immich-compress__"
 	TAG_COMPRESSED = "__compressed__"
+	TAG_RUNS       = "__runs__"
 )
 
+// TagCompressedAdd marks an asset as compressed and, when a run is set,
+// as part of the current run
 func (c *ClientSimple) TagCompressedAdd(assetID types.UUID) error {
-	_, err := c.client.BulkTagAssetsWithResponse(c.ctx, TagBulkAssetsDto{
+	tagCompressedID, err := c.TagCompressedID()
+	if err != nil {
+		return err
+	}
+	tagIds := []types.UUID{tagCompressedID}
+	if c.tags.runID != nil {
+		tagIds = append(tagIds, *c.tags.runID)
+	}
+	_, err = c.client.BulkTagAssetsWithResponse(c.ctx, TagBulkAssetsDto{
 		AssetIds: []types.UUID{assetID},
-		TagIds:   []types.UUID{c.tags.compressedID},
+		TagIds:   tagIds,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to attach tags: %w", err)
@@ -23,12 +35,97 @@ func (c *ClientSimple) TagCompressedAdd(assetID types.UUID) error {
 	return err
 }
 
+// TagRunSet creates the tag of a run below __immich-compress__/__runs__.
+// Every asset compressed afterwards gets it, so the run can be rolled back.
+func (c *ClientSimple) TagRunSet(runID string) error {
+	tagRootID, _, err := c.tagFindCreate(TAG_ROOT, nil)
+	if err != nil {
+		return err
+	}
+	tagRunsID, _, err := c.tagFindCreate(TAG_ROOT+"/"+TAG_RUNS, &tagRootID)
+	if err != nil {
+		return err
+	}
+	tagRunID, _, err := c.tagFindCreate(tagRunValue(runID), &tagRunsID)
+	if err != nil {
+		return err
+	}
+	c.tags.runID = &tagRunID
+	return nil
+}
+
+// TagRunFind returns the tag of a previous run
+func (c *ClientSimple) TagRunFind(runID string) (types.UUID, error) {
+	var uuid types.UUID
+	tag, err := c.tagFind(tagRunValue(runID))
+	if err != nil {
+		return uuid, err
+	}
+	if tag == nil {
+		return uuid, fmt.Errorf("run '%s' not found", runID)
+	}
+	return UUUIDOfString(tag.Id)
+}
+
+// TagFind returns the id of a tag by its full value, like "Travel/Italy",
+// or by its name when only one tag has it
+func (c *ClientSimple) TagFind(name string) (types.UUID, error) {
+	var uuid types.UUID
+	r, err := c.client.GetAllTagsWithResponse(c.ctx)
+	if err != nil {
+		return uuid, err
+	}
+	if r.JSON200 == nil {
+		return uuid, fmt.Errorf("bad status code: %s, body: %s", r.Status(), string(r.Body))
+	}
+
+	var found []string
+	for _, tagDto := range *r.JSON200 {
+		if tagDto.Value == name {
+			return UUUIDOfString(tagDto.Id)
+		}
+		if tagDto.Name == name {
+			found = append(found, tagDto.Id)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return uuid, fmt.Errorf("tag '%s' not found", name)
+	case 1:
+		return UUUIDOfString(found[0])
+	default:
+		return uuid, fmt.Errorf("tag name '%s' is ambiguous, use the full path", name)
+	}
+}
+
+// IsOwn reports whether the tag belongs to the __immich-compress__ tree
+func (t TagResponseDto) IsOwn() bool {
+	return t.Value == TAG_ROOT || strings.HasPrefix(t.Value, TAG_ROOT+"/")
+}
+
+// TagCompressedID returns the id of the __compressed__ tag. The tags are
+// created on first use, so a client that only reads writes nothing.
+func (c *ClientSimple) TagCompressedID() (types.UUID, error) {
+	// Workers share the tag, create it only once
+	c.tags.mu.Lock()
+	defer c.tags.mu.Unlock()
+	if c.tags.compressedID != nil {
+		return *c.tags.compressedID, nil
+	}
+	tagCompressedID, err := c.tagCompressedAt()
+	if err != nil {
+		return tagCompressedID, fmt.Errorf("can not get/create tags: %w", err)
+	}
+	c.tags.compressedID = &tagCompressedID
+	return tagCompressedID, nil
+}
+
 func (c *ClientSimple) tagCompressedAt() (types.UUID, error) {
 	tagRootID, _, err := c.tagFindCreate(TAG_ROOT, nil)
 	if err != nil {
 		return tagRootID, err
 	}
-	tagCompressID, _, err := c.tagFindCreate(TAG_COMPRESSED, &tagRootID)
+	tagCompressID, _, err := c.tagFindCreate(TAG_ROOT+"/"+TAG_COMPRESSED, &tagRootID)
 	if err != nil {
 		return tagCompressID, err
 	}
@@ -36,22 +133,43 @@ func (c *ClientSimple) tagCompressedAt() (types.UUID, error) {
 	return tagCompressID, err
 }
 
-func (c *ClientSimple) tagFindCreate(name string, parent *types.UUID) (types.UUID, *TagResponseDto, error) {
-	var uuid types.UUID
-	tagExists := false
+// tagRunValue is the full path of the tag of a run
+func tagRunValue(runID string) string {
+	return TAG_ROOT + "/" + TAG_RUNS + "/" + runID
+}
+
+// tagFind returns the tag with the full path value, like
+// "__immich-compress__/__compressed__". A user tag of the same name
+// elsewhere in the tree does not match.
+func (c *ClientSimple) tagFind(value string) (*TagResponseDto, error) {
 	var tagFound *TagResponseDto
 	r, err := c.client.GetAllTagsWithResponse(c.ctx)
 	if err != nil {
-		return uuid, tagFound, err
+		return tagFound, err
+	}
+	if r.JSON200 == nil {
+		return tagFound, fmt.Errorf("bad status code: %s, body: %s", r.Status(), string(r.Body))
 	}
 	for _, tagDto := range *r.JSON200 {
-		if name == tagDto.Name {
-			tagExists = true
+		if value == tagDto.Value {
 			tagFound = &tagDto
 		}
 	}
 
-	if !tagExists {
+	return tagFound, nil
+}
+
+// tagFindCreate returns the tag with the full path value, it is created
+// below parent with the last element of value as name when it is missing
+func (c *ClientSimple) tagFindCreate(value string, parent *types.UUID) (types.UUID, *TagResponseDto, error) {
+	var uuid types.UUID
+	tagFound, err := c.tagFind(value)
+	if err != nil {
+		return uuid, tagFound, err
+	}
+
+	if tagFound == nil {
+		name := value[strings.LastIndex(value, "/")+1:]
 		rc, err := c.client.CreateTagWithResponse(c.ctx, CreateTagJSONRequestBody{
 			Name:     name,
 			ParentId: parent,
@@ -59,6 +177,9 @@ func (c *ClientSimple) tagFindCreate(name string, parent *types.UUID) (types.UUI
 		if err != nil {
 			return uuid, tagFound, err
 		}
+		if rc.JSON201 == nil {
+			return uuid, tagFound, fmt.Errorf("can not create tag '%s': %s", name, rc.Status())
+		}
 		tagFound = rc.JSON201
 	}
 
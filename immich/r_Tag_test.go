@@ -0,0 +1,64 @@
+package immich
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTagRunFindIgnoresUserTag(t *testing.T) {
+	runID := "20240101-120000"
+	runTag := uuid.NewString()
+	server := newFakeServer(t)
+	server.JSON("GET /tags", http.StatusOK, []TagResponseDto{
+		{Id: uuid.NewString(), Name: runID, Value: runID},
+		{Id: runTag, Name: runID, Value: TAG_ROOT + "/" + TAG_RUNS + "/" + runID},
+		{Id: uuid.NewString(), Name: runID, Value: "Trips/" + runID},
+	})
+
+	found, err := server.client(t).TagRunFind(runID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if found.String() != runTag {
+		t.Errorf("Expected the run tag %s, got %s", runTag, found)
+	}
+
+	if _, err := server.client(t).TagRunFind("20240202-120000"); err == nil {
+		t.Error("Expected an error for an unknown run")
+	}
+}
+
+func TestTagCompressedIDIgnoresUserTag(t *testing.T) {
+	rootID := uuid.NewString()
+	compressedID := uuid.New()
+	server := newFakeServer(t)
+	server.JSON("GET /tags", http.StatusOK, []TagResponseDto{
+		{Id: rootID, Name: TAG_ROOT, Value: TAG_ROOT},
+		{Id: uuid.NewString(), Name: TAG_COMPRESSED, Value: "Family/" + TAG_COMPRESSED},
+	})
+	server.JSON("POST /tags", http.StatusCreated, TagResponseDto{
+		Id: compressedID.String(), Name: TAG_COMPRESSED, Value: TAG_ROOT + "/" + TAG_COMPRESSED,
+	})
+
+	found, err := server.client(t).TagCompressedID()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if found != compressedID {
+		t.Errorf("Expected the created tag %s, got %s", compressedID, found)
+	}
+	creates := server.Received(http.MethodPost, "/tags")
+	if len(creates) != 1 {
+		t.Fatalf("Expected one tag to be created, got %d", len(creates))
+	}
+	var body CreateTagJSONRequestBody
+	if err := json.Unmarshal(creates[0].Body, &body); err != nil {
+		t.Fatalf("Invalid body %s: %v", creates[0].Body, err)
+	}
+	if body.Name != TAG_COMPRESSED || body.ParentId == nil || body.ParentId.String() != rootID {
+		t.Errorf("Expected %s below the root tag, got %s", TAG_COMPRESSED, creates[0].Body)
+	}
+}
@@ -0,0 +1,150 @@
+package immich
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry configures how requests that failed with a transient error are
+// repeated. The pause before each retry doubles from MinDelay up to
+// MaxDelay, half of it is random so parallel workers do not retry in
+// lockstep.
+type Retry struct {
+	// Retries is the number of retries after the first attempt, 0 disables
+	// retrying
+	Retries  int
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+func (r Retry) validate() error {
+	if r.Retries < 0 {
+		return fmt.Errorf("retries can not be negative")
+	}
+	if r.Retries > 0 && (r.MinDelay <= 0 || r.MaxDelay < r.MinDelay) {
+		return fmt.Errorf("retry delays need 0 < min %s <= max %s", r.MinDelay, r.MaxDelay)
+	}
+	return nil
+}
+
+// delay returns the pause before the retry after attempt, counted from 0.
+// A Retry-After of the server is honored, false when it asks for a longer
+// pause than MaxDelay.
+func (r Retry) delay(attempt int, resp *http.Response, now time.Time) (time.Duration, bool) {
+	backoff := r.MinDelay
+	for i := 0; i < attempt && backoff < r.MaxDelay; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, r.MaxDelay)
+	delay := backoff/2 + rand.N(backoff/2+1)
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After"), now); ok {
+			if after > r.MaxDelay {
+				return 0, false
+			}
+			delay = max(delay, after)
+		}
+	}
+	return delay, true
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+type retrySafeKey struct{}
+
+// retrySafe marks the requests made with ctx as safe to repeat although
+// their method is POST, like searches or uploads the server recognizes by
+// their checksum
+func retrySafe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retrySafeKey{}, true)
+}
+
+// replayable reports whether req can be sent again: its method is
+// idempotent or it is marked by retrySafe, and its body can be recreated
+func replayable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	safe, _ := req.Context().Value(retrySafeKey{}).(bool)
+	return safe
+}
+
+// transient reports whether the failure may go away by itself: network
+// errors, rate limits and server errors, mostly of a proxy in front of Immich
+func transient(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented)
+}
+
+// retryDoer repeats replayable requests that failed with a transient error
+type retryDoer struct {
+	doer  HttpRequestDoer
+	retry Retry
+}
+
+func (d *retryDoer) Do(req *http.Request) (*http.Response, error) {
+	if d.retry.Retries == 0 || !replayable(req) {
+		return d.doer.Do(req)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := d.doer.Do(req)
+		if attempt == d.retry.Retries || !transient(req, resp, err) {
+			return resp, err
+		}
+		delay, ok := d.retry.delay(attempt, resp, time.Now())
+		if !ok {
+			return resp, err
+		}
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			// Draining lets the connection be reused
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		fmt.Printf("↻ Retrying %s %s in %s (%s)\n", req.Method, req.URL.Path, delay.Round(time.Millisecond), reason)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to recreate the request body: %w", err)
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
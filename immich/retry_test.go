@@ -0,0 +1,215 @@
+package immich
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var retryFast = Retry{Retries: 3, MinDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// failingServer answers the first failures requests with status, then 200
+// with the request body
+func failingServer(t *testing.T, failures int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if requests.Add(1) <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryDoerRetriesTransient(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		method   string
+		ctx      context.Context
+		retry    Retry
+		expected int32
+	}{
+		{"get 502", http.StatusBadGateway, http.MethodGet, context.Background(), retryFast, 3},
+		{"get 429", http.StatusTooManyRequests, http.MethodGet, context.Background(), retryFast, 3},
+		{"get 404", http.StatusNotFound, http.MethodGet, context.Background(), retryFast, 1},
+		{"get 501", http.StatusNotImplemented, http.MethodGet, context.Background(), retryFast, 1},
+		{"post not safe", http.StatusBadGateway, http.MethodPost, context.Background(), retryFast, 1},
+		{"post safe", http.StatusBadGateway, http.MethodPost, retrySafe(context.Background()), retryFast, 3},
+		{"disabled", http.StatusBadGateway, http.MethodGet, context.Background(), Retry{}, 1},
+		{"retries used up", http.StatusBadGateway, http.MethodGet, context.Background(), Retry{Retries: 1, MinDelay: time.Millisecond, MaxDelay: time.Millisecond}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := failingServer(t, 2, tt.status, nil)
+			req, err := http.NewRequestWithContext(tt.ctx, tt.method, server.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			doer := &retryDoer{doer: server.Client(), retry: tt.retry}
+			resp, err := doer.Do(req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if requests.Load() != tt.expected {
+				t.Errorf("Expected %d requests, got %d", tt.expected, requests.Load())
+			}
+			if resp.StatusCode == http.StatusOK && string(body) != "payload" {
+				t.Errorf("Expected the body to be sent again, got %q", body)
+			}
+		})
+	}
+}
+
+func TestRetryDoerRetryAfter(t *testing.T) {
+	server, requests := failingServer(t, 1, http.StatusServiceUnavailable, http.Header{"Retry-After": {"60"}})
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	resp, err := (&retryDoer{doer: server.Client(), retry: retryFast}).Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || requests.Load() != 1 {
+		t.Errorf("Expected no retry when Retry-After exceeds the max delay, got %d after %d requests", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestRetryDoerCancelled(t *testing.T) {
+	server, requests := failingServer(t, 5, http.StatusBadGateway, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = (&retryDoer{doer: server.Client(), retry: Retry{Retries: 5, MinDelay: time.Hour, MaxDelay: time.Hour}}).Do(req)
+	if err != context.Canceled || requests.Load() != 1 {
+		t.Errorf("Expected the pause to end with the context, got %v after %d requests", err, requests.Load())
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	retry := Retry{Retries: 10, MinDelay: time.Second, MaxDelay: 10 * time.Second}
+	for attempt, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		delay, ok := retry.delay(attempt, nil, time.Now())
+		if !ok || delay < backoff/2 || delay > backoff {
+			t.Errorf("Attempt %d: expected a delay between %s and %s, got %s", attempt, backoff/2, backoff, delay)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": {"5"}}}
+	if delay, ok := retry.delay(0, resp, time.Now()); !ok || delay != 5*time.Second {
+		t.Errorf("Expected the Retry-After of 5s, got %s", delay)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"Wed, 01 Jan 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Jan 2025 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+		{"-1", 0, false},
+	}
+	for _, tt := range tests {
+		delay, ok := retryAfter(tt.value, now)
+		if delay != tt.expected || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %s %v, expected %s %v", tt.value, delay, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestRetryValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   Retry
+		wantErr bool
+	}{
+		{"disabled", Retry{}, false},
+		{"valid", Retry{Retries: 4, MinDelay: time.Second, MaxDelay: 30 * time.Second}, false},
+		{"negative", Retry{Retries: -1}, true},
+		{"no delay", Retry{Retries: 4, MaxDelay: time.Second}, true},
+		{"max below min", Retry{Retries: 4, MinDelay: time.Minute, MaxDelay: time.Second}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.retry.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+// TestRetryDoerReplaysUpload verifies a failed upload is sent again with the
+// whole file
+func TestRetryDoerReplaysUpload(t *testing.T) {
+	content := strings.Repeat("compressed image data ", 10_000)
+	file, err := os.Create(filepath.Join(t.TempDir(), "image.jxl"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	var lastBody atomic.Value
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lastBody.Store(string(body))
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	openBody, contentType, err := assetUploadMultipartBody(&uploadAssetBody{Filename: "image.jxl"}, file)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	req, err := http.NewRequestWithContext(retrySafe(context.Background()), http.MethodPost, server.URL, openBody())
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.GetBody = func() (io.ReadCloser, error) { return openBody(), nil }
+	resp, err := (&retryDoer{doer: server.Client(), retry: retryFast}).Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests.Load() != 2 {
+		t.Fatalf("Expected the upload to succeed on the second request, got %d after %d", resp.StatusCode, requests.Load())
+	}
+	fields := readMultipartFields(t, strings.NewReader(lastBody.Load().(string)), contentType)
+	if fields["filename"] != "image.jxl" {
+		t.Errorf("Expected the fields of the upload, got %v", fields)
+	}
+	if !strings.Contains(lastBody.Load().(string), content) {
+		t.Error("Expected the whole file in the retried upload")
+	}
+}